@@ -0,0 +1,666 @@
+// Package compiler lowers resolved modules into bytecode for the
+// stack-based VM in package eval. The bytecode works on the same
+// environments as the tree-walking evaluator, and variables are found
+// using the distances recorded by the resolver -- except that blocks
+// without variables get no environment, so the distances are adjusted
+// to skip them. Compiled and interpreted functions can still call each
+// other, and close over each other's variables.
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"toe/diag"
+	"toe/lexer"
+	"toe/parser"
+	"unicode/utf8"
+)
+
+// Code is a compiled module or function body.
+type Code struct {
+	Filename  string
+	Function  *parser.Function // nil for modules.
+	Ops       []byte
+	Consts    []interface{} // float64 or string literals.
+	Names     []string      // identifiers, slot names and operators.
+	Funcs     []*Code       // nested functions, see OP_CLOSURE.
+	Positions []Position    // sorted by PC.
+	Caches    int           // number of inline caches used by slot lookups.
+	names     map[string]int
+	consts    map[interface{}]int
+}
+
+// Position maps the instruction at PC back to the span of its token,
+// so that runtime errors get the same stack as in the evaluator.
+type Position struct {
+	PC        int
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+}
+
+// Position returns the source position of the instruction at pc.
+func (c *Code) Position(pc int) (Position, bool) {
+	i := sort.Search(len(c.Positions), func(i int) bool { return c.Positions[i].PC >= pc })
+	if i < len(c.Positions) && c.Positions[i].PC == pc {
+		return c.Positions[i], true
+	}
+	return Position{}, false
+}
+
+// Name returns a human readable name for the code.
+func (c *Code) Name() string {
+	if c.Function == nil {
+		return "[Module]"
+	}
+	if c.Function.Name == "" {
+		return "[Function]"
+	}
+	return c.Function.Name
+}
+
+// Disassemble returns a listing of the instructions in c, followed by
+// the listings of any nested functions.
+func (c *Code) Disassemble() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "== %s ==\n", c.Name())
+	for pc := 0; pc < len(c.Ops); {
+		op := Opcode(c.Ops[pc])
+		fmt.Fprintf(&buf, "%04d %s", pc, op)
+		pc++
+		for i := 0; i < op.Operands(); i++ {
+			fmt.Fprintf(&buf, " %d", ReadOperand(c.Ops, pc))
+			pc += 2
+		}
+		buf.WriteString("\n")
+	}
+	for _, f := range c.Funcs {
+		buf.WriteString(f.Disassemble())
+	}
+	return buf.String()
+}
+
+// ReadOperand decodes the operand at ops[pc:].
+func ReadOperand(ops []byte, pc int) int {
+	return int(ops[pc])<<8 | int(ops[pc+1])
+}
+
+type CompileError struct {
+	Filename string
+	Token    lexer.Token
+	Message  string
+}
+
+func (ce CompileError) Error() string  { return ce.String() }
+func (ce CompileError) String() string { return ce.Diagnostic().String() }
+func (ce CompileError) Diagnostic() diag.Diagnostic {
+	return diag.Diagnostic{
+		Filename: ce.Filename,
+		Line:     ce.Token.Line,
+		Column:   ce.Token.Column,
+		Length:   utf8.RuneCountInString(ce.Token.Lexeme),
+		Kind:     "compiler",
+		Message:  ce.Message,
+	}
+}
+
+// Control flow constructs which break, continue and return have to
+// unwind through.
+type control struct {
+	loop    bool
+	iter    bool          // for loops keep their iterator on the stack.
+	finally *parser.Block // try statements with a finally clause.
+	handler bool          // whether the construct has a handler installed.
+	scope   int           // environment depth of the loop body or try statement
+	scopes  int           // the number of scopes enclosing it, see compiler.scopes
+	temps   int           // values kept on the stack, e.g. iterators
+	start   int           // where continue jumps to.
+	breaks  []int         // jumps to patch to the end of the loop.
+}
+
+type compiler struct {
+	code *Code
+	// scopes has an entry for each of the resolver's scopes enclosing the
+	// code (including those outside of the function), which is false if
+	// the scope has no environment at runtime.
+	scopes []bool
+	scope  int // the number of environments pushed by the code.
+	temps  int
+	ctrl   []*control
+	tok    lexer.Token // for error reporting
+	errors *[]error
+}
+
+// Compile compiles a module which has already been resolved.
+func Compile(module *parser.Module) (*Code, []error) {
+	return compileModule(module, false)
+}
+
+// CompileInteractive is like Compile, but the code returns the value of
+// the last statement instead of nil, for the REPL.
+func CompileInteractive(module *parser.Module) (*Code, []error) {
+	return compileModule(module, true)
+}
+
+func compileModule(module *parser.Module, tail bool) (*Code, []error) {
+	errors := []error{}
+	c := newCompiler(module.Filename, nil, &errors)
+	c.scopes = []bool{true} // the module's environment.
+	c.stmts(module.Stmts, tail)
+	if !tail {
+		c.emit(OP_NIL)
+	}
+	c.emit(OP_RETURN)
+	if len(errors) != 0 {
+		return nil, errors
+	}
+	return c.code, nil
+}
+
+func newCompiler(filename string, fn *parser.Function, errors *[]error) *compiler {
+	c := &compiler{
+		code: &Code{
+			Filename: filename,
+			Function: fn,
+			names:    map[string]int{},
+			consts:   map[interface{}]int{},
+		},
+		errors: errors,
+	}
+	if fn != nil {
+		c.tok = fn.Fn
+	}
+	return c
+}
+
+func (c *compiler) err(msg string) {
+	*c.errors = append(*c.errors, CompileError{
+		Filename: c.code.Filename,
+		Token:    c.tok,
+		Message:  msg,
+	})
+}
+
+// ========
+// Emitting
+// ========
+
+func (c *compiler) emit(op Opcode, args ...int) int {
+	c.code.Ops = append(c.code.Ops, byte(op))
+	for _, arg := range args {
+		if arg < 0 || arg > 0xFFFF {
+			c.err("function is too large to compile")
+			arg = 0
+		}
+		c.code.Ops = append(c.code.Ops, byte(arg>>8), byte(arg))
+	}
+	return len(c.code.Ops)
+}
+
+// emitAt is like emit, but records the position of the instruction.
+func (c *compiler) emitAt(tok lexer.Token, op Opcode, args ...int) int {
+	c.code.Positions = append(c.code.Positions, Position{
+		PC:        len(c.code.Ops),
+		Line:      tok.Line,
+		Column:    tok.Column,
+		EndLine:   tok.EndLine,
+		EndColumn: tok.EndColumn,
+	})
+	return c.emit(op, args...)
+}
+
+// emitJump emits a jump to be patched later, returning the position of
+// its target operand.
+func (c *compiler) emitJump(op Opcode) int {
+	return c.emit(op, 0) - 2
+}
+
+func (c *compiler) patch(at int) {
+	target := len(c.code.Ops)
+	if target > 0xFFFF {
+		c.err("function is too large to compile")
+	}
+	c.code.Ops[at] = byte(target >> 8)
+	c.code.Ops[at+1] = byte(target)
+}
+
+func (c *compiler) name(name string) int {
+	if idx, ok := c.code.names[name]; ok {
+		return idx
+	}
+	c.code.Names = append(c.code.Names, name)
+	c.code.names[name] = len(c.code.Names) - 1
+	return len(c.code.Names) - 1
+}
+
+func (c *compiler) constant(v interface{}) int {
+	if idx, ok := c.code.consts[v]; ok {
+		return idx
+	}
+	c.code.Consts = append(c.code.Consts, v)
+	c.code.consts[v] = len(c.code.Consts) - 1
+	return len(c.code.Consts) - 1
+}
+
+// cache allocates an inline cache for a slot lookup.
+func (c *compiler) cache() int {
+	c.code.Caches++
+	return c.code.Caches - 1
+}
+
+func (c *compiler) pushEnv(size int) { c.emit(OP_PUSH_ENV, size); c.scope++ }
+func (c *compiler) popEnv()          { c.emit(OP_POP_ENV); c.scope-- }
+
+// pushScope enters a new scope with the given number of variables,
+// pushing an environment unless it has none.
+func (c *compiler) pushScope(size int) {
+	c.scopes = append(c.scopes, size > 0)
+	if size > 0 {
+		c.pushEnv(size)
+	}
+}
+
+func (c *compiler) popScope() {
+	if c.scopes[len(c.scopes)-1] {
+		c.popEnv()
+	}
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// depth converts the resolver's distance to a scope into the number of
+// environments to go up at runtime.
+func (c *compiler) depth(loc int) int {
+	depth := 0
+	for _, env := range c.scopes[len(c.scopes)-loc:] {
+		if env {
+			depth++
+		}
+	}
+	return depth
+}
+
+// ==========
+// Statements
+// ==========
+//
+// Statements leave the stack as they found it -- except that the last
+// statement of a function body is compiled in `tail' mode, where it
+// leaves its value on the stack. This mirrors the evaluator, where a
+// function without a return evaluates to its last statement.
+
+func (c *compiler) stmts(stmts []parser.Stmt, tail bool) {
+	for i, stmt := range stmts {
+		c.stmt(stmt, tail && i == len(stmts)-1)
+	}
+	if tail && len(stmts) == 0 {
+		c.emit(OP_NIL)
+	}
+}
+
+func (c *compiler) block(node *parser.Block, tail bool) {
+	c.pushScope(node.Size)
+	c.stmts(node.Stmts, tail)
+	c.popScope()
+}
+
+func (c *compiler) stmt(node parser.Stmt, tail bool) {
+	switch node := node.(type) {
+	case *parser.Let:
+		c.expr(node.Value)
+		if node.Slot < 0 {
+			c.emit(OP_DEFINE_NAME, c.name(node.Name.Lexeme))
+		} else {
+			c.emit(OP_DEFINE, node.Slot)
+		}
+		c.tailNil(tail)
+	case *parser.Block:
+		c.block(node, tail)
+	case *parser.ExprStmt:
+		c.expr(node.Expr)
+		if !tail {
+			c.emit(OP_POP)
+		}
+	case *parser.If:
+		c.ifStmt(node, tail)
+	case *parser.While:
+		c.whileStmt(node)
+		c.tailNil(tail)
+	case *parser.For:
+		c.forStmt(node)
+		c.tailNil(tail)
+	case *parser.Break:
+		loop := c.unwindLoop()
+		loop.breaks = append(loop.breaks, c.emitJump(OP_JUMP))
+	case *parser.Continue:
+		loop := c.unwindLoop()
+		c.emit(OP_JUMP, loop.start)
+	case *parser.Return:
+		c.returnStmt(node)
+	case *parser.Try:
+		c.tryStmt(node, tail)
+	default:
+		panic(fmt.Sprintf("unhandled node %#+v", node))
+	}
+}
+
+func (c *compiler) tailNil(tail bool) {
+	if tail {
+		c.emit(OP_NIL)
+	}
+}
+
+func (c *compiler) ifStmt(node *parser.If, tail bool) {
+	c.expr(node.Cond)
+	elseJump := c.emitJump(OP_JUMP_FALSE)
+	c.stmt(node.Then, tail)
+	endJump := c.emitJump(OP_JUMP)
+	c.patch(elseJump)
+	if node.Else != nil {
+		c.stmt(node.Else, tail)
+	} else {
+		c.tailNil(tail)
+	}
+	c.patch(endJump)
+}
+
+func (c *compiler) whileStmt(node *parser.While) {
+	loop := &control{loop: true, scope: c.scope, scopes: len(c.scopes), temps: c.temps}
+	loop.start = len(c.code.Ops)
+	c.expr(node.Cond)
+	exit := c.emitJump(OP_JUMP_FALSE)
+	c.ctrl = append(c.ctrl, loop)
+	c.stmt(node.Stmt, false)
+	c.ctrl = c.ctrl[:len(c.ctrl)-1]
+	c.emit(OP_JUMP, loop.start)
+	c.patch(exit)
+	for _, at := range loop.breaks {
+		c.patch(at)
+	}
+}
+
+// for (x : iter) stmt is compiled as:
+//
+//	       <iter>; OP_ITER; OP_PUSH_ENV 1; OP_SETUP_TRY handler
+//	start: OP_FOR_NEXT exit; <stmt>; OP_JUMP start
+//	exit:  OP_POP_TRY; OP_POP_ENV; OP_CLOSE_ITER; OP_JUMP end
+//	handler: OP_CLOSE_QUIET; OP_RETHROW
+//	end:
+//
+// i.e. the iterator is closed however we leave the loop.
+func (c *compiler) forStmt(node *parser.For) {
+	c.expr(node.Iter)
+	c.emitAt(node.Keyword, OP_ITER)
+	c.temps++
+	c.pushScope(1)
+	handler := c.emitJump(OP_SETUP_TRY)
+	loop := &control{loop: true, iter: true, handler: true, scope: c.scope, scopes: len(c.scopes), temps: c.temps}
+	loop.start = len(c.code.Ops)
+	exit := c.emitAt(node.Keyword, OP_FOR_NEXT, 0) - 2
+	c.ctrl = append(c.ctrl, loop)
+	c.stmt(node.Stmt, false)
+	c.ctrl = c.ctrl[:len(c.ctrl)-1]
+	c.emit(OP_JUMP, loop.start)
+	c.patch(exit)
+	for _, at := range loop.breaks {
+		c.patch(at)
+	}
+	c.emit(OP_POP_TRY)
+	c.popScope()
+	c.emitAt(node.Keyword, OP_CLOSE_ITER)
+	c.temps--
+	end := c.emitJump(OP_JUMP)
+	c.patch(handler)
+	c.emit(OP_CLOSE_QUIET)
+	c.emit(OP_RETHROW)
+	c.patch(end)
+}
+
+func (c *compiler) returnStmt(node *parser.Return) {
+	if node.Expr != nil {
+		c.expr(node.Expr)
+	} else {
+		c.emit(OP_NIL)
+	}
+	// the return value is kept on the stack while we unwind, so finally
+	// blocks see it as a temporary.
+	temps := c.temps
+	scope := c.scope
+	c.temps++
+	for i := len(c.ctrl) - 1; i >= 0; i-- {
+		ctrl := c.ctrl[i]
+		if ctrl.handler {
+			c.emit(OP_POP_TRY)
+		}
+		if ctrl.iter {
+			c.emit(OP_CLOSE_AT, ctrl.temps-1)
+		}
+		if ctrl.finally != nil {
+			for c.scope > ctrl.scope {
+				c.popEnv()
+			}
+			c.inlineFinally(i)
+		}
+	}
+	c.emit(OP_RETURN)
+	c.temps = temps
+	c.scope = scope
+}
+
+// unwindLoop emits the code needed before jumping to the start or
+// end of the innermost loop: running finally blocks, removing
+// handlers, environments and temporaries.
+func (c *compiler) unwindLoop() *control {
+	temps := c.temps
+	scope := c.scope
+	for i := len(c.ctrl) - 1; i >= 0; i-- {
+		ctrl := c.ctrl[i]
+		for c.scope > ctrl.scope {
+			c.popEnv()
+		}
+		for c.temps > ctrl.temps {
+			c.emit(OP_POP)
+			c.temps--
+		}
+		if ctrl.loop {
+			c.temps = temps
+			c.scope = scope
+			return ctrl
+		}
+		if ctrl.handler {
+			c.emit(OP_POP_TRY)
+		}
+		if ctrl.finally != nil {
+			c.inlineFinally(i)
+		}
+	}
+	panic("break or continue outside of loop")
+}
+
+// inlineFinally compiles the finally block of c.ctrl[i], as if it
+// were outside of the try statement.
+func (c *compiler) inlineFinally(i int) {
+	ctrl := c.ctrl[i]
+	saved, scopes := c.ctrl, c.scopes
+	c.ctrl = c.ctrl[:i:i]
+	c.scopes = c.scopes[:ctrl.scopes:ctrl.scopes]
+	c.block(ctrl.finally, false)
+	c.ctrl, c.scopes = saved, scopes
+}
+
+// try B catch (e) C finally F is compiled as:
+//
+//	       OP_SETUP_TRY catch; <B>; OP_POP_TRY; OP_JUMP finally
+//	catch: OP_SETUP_TRY rethrow
+//	       OP_PUSH_ENV 1; OP_CAUGHT; OP_DEFINE 0; <C>; OP_POP_ENV
+//	       OP_POP_TRY
+//	finally: <F>; OP_JUMP end
+//	rethrow: <F>; OP_RETHROW
+//	end:
+//
+// Without a catch clause, errors go straight to rethrow. Any break,
+// continue or return inside B or C runs a copy of F on the way out.
+func (c *compiler) tryStmt(node *parser.Try, tail bool) {
+	ctrl := &control{
+		finally: node.Finally,
+		handler: true,
+		scope:   c.scope,
+		scopes:  len(c.scopes),
+		temps:   c.temps,
+	}
+	handler := c.emitJump(OP_SETUP_TRY)
+	c.ctrl = append(c.ctrl, ctrl)
+	c.block(node.Body, tail)
+	c.emit(OP_POP_TRY)
+	finally := c.emitJump(OP_JUMP)
+	rethrow := -1
+	if node.Catch != nil {
+		c.patch(handler)
+		ctrl.handler = node.Finally != nil
+		if ctrl.handler {
+			rethrow = c.emitJump(OP_SETUP_TRY)
+		}
+		c.pushScope(1)
+		c.emit(OP_CAUGHT)
+		c.emit(OP_DEFINE, 0)
+		c.block(node.Catch, tail)
+		c.popScope()
+		if ctrl.handler {
+			c.emit(OP_POP_TRY)
+		}
+	} else {
+		// errors jump straight to the rethrow path.
+		rethrow = handler
+	}
+	c.ctrl = c.ctrl[:len(c.ctrl)-1]
+	c.patch(finally)
+	if node.Finally == nil {
+		return
+	}
+	if tail {
+		c.temps++
+	}
+	c.block(node.Finally, false)
+	if tail {
+		c.temps--
+	}
+	end := c.emitJump(OP_JUMP)
+	c.patch(rethrow)
+	c.temps++ // the error being rethrown.
+	c.block(node.Finally, false)
+	c.temps--
+	c.emit(OP_RETHROW)
+	c.patch(end)
+}
+
+// ===========
+// Expressions
+// ===========
+
+func (c *compiler) expr(node parser.Expr) {
+	switch node := node.(type) {
+	case *parser.Literal:
+		switch node.Lit.Type {
+		case lexer.STRING:
+			c.emit(OP_CONST, c.constant(node.Lit.Literal.(string)))
+		case lexer.NUMBER:
+			c.emit(OP_CONST, c.constant(node.Lit.Literal.(float64)))
+		case lexer.NIL:
+			c.emit(OP_NIL)
+		case lexer.TRUE:
+			c.emit(OP_TRUE)
+		case lexer.FALSE:
+			c.emit(OP_FALSE)
+		}
+	case *parser.Identifier:
+		if node.Slot < 0 {
+			c.emitAt(node.Id, OP_GET_NAME, c.depth(node.Loc), c.name(node.Id.Lexeme))
+		} else {
+			c.emitAt(node.Id, OP_GET_VAR, c.depth(node.Loc), node.Slot, c.name(node.Id.Lexeme))
+		}
+	case *parser.Assign:
+		c.expr(node.Right)
+		if node.Slot < 0 {
+			c.emit(OP_SET_NAME, c.depth(node.Loc), c.name(node.Name.Lexeme))
+		} else {
+			c.emit(OP_SET_VAR, c.depth(node.Loc), node.Slot)
+		}
+	case *parser.Binary:
+		c.expr(node.Left)
+		c.expr(node.Right)
+		c.emitAt(node.Op, OP_BINARY, c.name(node.Op.Lexeme), c.cache())
+	case *parser.And:
+		c.expr(node.Left)
+		end := c.emitJump(OP_AND)
+		c.expr(node.Right)
+		c.patch(end)
+	case *parser.Or:
+		c.expr(node.Left)
+		end := c.emitJump(OP_OR)
+		c.expr(node.Right)
+		c.patch(end)
+	case *parser.Unary:
+		c.expr(node.Right)
+		c.emitAt(node.Op, OP_UNARY, int(node.Op.Type))
+	case *parser.Get:
+		c.expr(node.Object)
+		c.emitAt(node.Name, OP_GET_SLOT, c.name(node.Name.Lexeme), c.cache())
+	case *parser.Set:
+		c.expr(node.Right)
+		c.expr(node.Object)
+		c.emitAt(node.Name, OP_SET_SLOT, c.name(node.Name.Lexeme))
+	case *parser.Index:
+		c.expr(node.Object)
+		c.expr(node.Key)
+		c.emitAt(node.LBracket, OP_INDEX)
+	case *parser.SetIndex:
+		c.expr(node.Right)
+		c.expr(node.Object)
+		c.expr(node.Key)
+		c.emitAt(node.LBracket, OP_SET_INDEX)
+	case *parser.Method:
+		c.expr(node.Object)
+		c.emitAt(node.Name, OP_GET_METHOD, c.name(node.Name.Lexeme), c.cache())
+		for _, arg := range node.Args {
+			c.expr(arg)
+		}
+		c.emitAt(node.LParen, OP_CALL_METHOD, len(node.Args))
+	case *parser.Call:
+		c.expr(node.Callee)
+		for _, arg := range node.Args {
+			c.expr(arg)
+		}
+		c.emitAt(node.LParen, OP_CALL, len(node.Args))
+	case *parser.Array:
+		for _, expr := range node.Exprs {
+			c.expr(expr)
+		}
+		c.emit(OP_ARRAY, len(node.Exprs))
+	case *parser.Hash:
+		c.emit(OP_HASH)
+		for _, pair := range node.Pairs {
+			c.expr(pair.Key)
+			c.expr(pair.Value)
+			c.emitAt(node.LBrace, OP_HASH_INSERT)
+		}
+	case *parser.Function:
+		c.emit(OP_CLOSURE, c.function(node))
+	case *parser.Super:
+		c.emitAt(node.Tok, OP_SUPER)
+	default:
+		panic(fmt.Sprintf("unhandled node %#+v", node))
+	}
+}
+
+// function compiles the body of a function expression, returning its
+// index in Funcs. The parameters are bound by the caller.
+func (c *compiler) function(node *parser.Function) int {
+	fc := newCompiler(c.code.Filename, node, c.errors)
+	// the environment of `this' and the parameters.
+	fc.scopes = append(c.scopes[:len(c.scopes):len(c.scopes)], true)
+	fc.block(node.Body, true)
+	fc.emit(OP_RETURN)
+	c.code.Funcs = append(c.code.Funcs, fc.code)
+	return len(c.code.Funcs) - 1
+}
@@ -0,0 +1,136 @@
+package compiler_test
+
+import (
+	"testing"
+	"toe/compiler"
+	"toe/lexer"
+	"toe/parser"
+	"toe/resolver"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\na = a + 2;", `== [Module] ==
+0000 OP_CONST 0
+0003 OP_DEFINE_NAME 0
+0006 OP_GET_NAME 0 0
+0011 OP_CONST 1
+0014 OP_BINARY 1 0
+0019 OP_SET_NAME 0 0
+0024 OP_POP
+0025 OP_NIL
+0026 OP_RETURN
+`},
+		{"let f = fn(x) { return x; };\nf(1);", `== [Module] ==
+0000 OP_CLOSURE 0
+0003 OP_DEFINE_NAME 0
+0006 OP_GET_NAME 0 0
+0011 OP_CONST 0
+0014 OP_CALL 1
+0017 OP_POP
+0018 OP_NIL
+0019 OP_RETURN
+== f ==
+0000 OP_GET_VAR 0 1 0
+0007 OP_RETURN
+0008 OP_RETURN
+`},
+		// blocks without variables get no environment.
+		{"while (true) { break; }", `== [Module] ==
+0000 OP_TRUE
+0001 OP_JUMP_FALSE 10
+0004 OP_JUMP 10
+0007 OP_JUMP 0
+0010 OP_NIL
+0011 OP_RETURN
+`},
+		{"if (true) { let a = 1;\nlet f = fn(x) { return a + x; };\na = f(2); }", `== [Module] ==
+0000 OP_TRUE
+0001 OP_JUMP_FALSE 42
+0004 OP_PUSH_ENV 2
+0007 OP_CONST 0
+0010 OP_DEFINE 0
+0013 OP_CLOSURE 0
+0016 OP_DEFINE 1
+0019 OP_GET_VAR 0 1 0
+0026 OP_CONST 1
+0029 OP_CALL 1
+0032 OP_SET_VAR 0 0
+0037 OP_POP
+0038 OP_POP_ENV
+0039 OP_JUMP 42
+0042 OP_NIL
+0043 OP_RETURN
+== f ==
+0000 OP_GET_VAR 1 0 0
+0007 OP_GET_VAR 0 1 1
+0014 OP_BINARY 2 0
+0019 OP_RETURN
+0020 OP_RETURN
+`},
+	}
+	for i, test := range tests {
+		code := compile(t, test.input)
+		if code == nil {
+			continue
+		}
+		if got := code.Disassemble(); got != test.expected {
+			t.Errorf("tests[%d]: expected:\n%s\ngot:\n%s", i, test.expected, got)
+		}
+	}
+}
+
+func TestCompilePositions(t *testing.T) {
+	code := compile(t, "let a = 1;\na = a + 2;")
+	if code == nil {
+		return
+	}
+	// OP_BINARY at 14 should point at the `+'.
+	pos, ok := code.Position(14)
+	if !ok {
+		t.Fatalf("expected a position for pc=14")
+	}
+	if pos.Line != 2 || pos.Column != 7 {
+		t.Errorf("expected 2:7, got=%d:%d", pos.Line, pos.Column)
+	}
+	if _, ok := code.Position(15); ok {
+		t.Errorf("expected no position for pc=15")
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Code {
+	l := lexer.New("", input)
+	l.ScanTokens()
+	if !noErrors(t, "lexer", l.Errors) {
+		return nil
+	}
+	p := parser.New("", l.Tokens)
+	module := p.Parse()
+	if !noErrors(t, "parser", p.Errors) {
+		return nil
+	}
+	r := resolver.New(module)
+	r.Resolve()
+	if !noErrors(t, "resolver", r.Errors) {
+		return nil
+	}
+	code, errs := compiler.Compile(module)
+	if !noErrors(t, "compiler", errs) {
+		return nil
+	}
+	return code
+}
+
+func noErrors(t *testing.T, src string, errors []error) bool {
+	if len(errors) != 0 {
+		t.Errorf("got %s errors:\n", src)
+		for _, x := range errors {
+			t.Errorf("%s\n", x)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package compiler
+
+//go:generate stringer -type=Opcode
+
+type Opcode uint8
+
+// Every operand is an unsigned 16-bit big-endian integer. The comments
+// show the operands, and the effect on the value stack.
+const (
+	_              = Opcode(iota)
+	OP_CONST       // idx          -- push Consts[idx]
+	OP_NIL         //              -- push nil
+	OP_TRUE        //              -- push true
+	OP_FALSE       //              -- push false
+	OP_POP         //              -- pop
+	OP_GET_VAR     // depth, slot, name -- push env.ancestor(depth).slots[slot]
+	OP_SET_VAR     // depth, slot  -- env.ancestor(depth).slots[slot] = top
+	OP_DEFINE      // slot         -- env.slots[slot] = pop
+	OP_GET_NAME    // depth, name  -- push env.ancestor(depth)[name]
+	OP_SET_NAME    // depth, name  -- env.ancestor(depth)[name] = top
+	OP_DEFINE_NAME // name         -- env[name] = pop
+	OP_PUSH_ENV    // size         -- push a new environment with size slots
+	OP_POP_ENV     //              -- pop the current environment
+	OP_BINARY      // name, cache  -- l, r => l `name' r
+	OP_UNARY       // token type   -- x => op x
+	OP_JUMP        // target       -- pc = target
+	OP_JUMP_FALSE  // target       -- jump if pop is falsy
+	OP_AND         // target       -- jump if top is falsy, else pop
+	OP_OR          // target       -- jump if top is truthy, else pop
+	OP_GET_SLOT    // name, cache  -- obj => obj.name
+	OP_SET_SLOT    // name         -- right, obj => right (obj.name = right)
+	OP_INDEX       //              -- obj, key => obj.get(key)
+	OP_SET_INDEX   //              -- right, obj, key => right (obj.set(key, right))
+	OP_GET_METHOD  // name, cache  -- obj => this, fn, whence
+	OP_CALL_METHOD // argc         -- this, fn, whence, args... => rv
+	OP_CALL        // argc         -- fn, args... => rv
+	OP_ARRAY       // n            -- values... => array
+	OP_HASH        //              -- push a new hash
+	OP_HASH_INSERT //              -- hash, k, v => hash
+	OP_CLOSURE     // idx          -- push a closure of Funcs[idx]
+	OP_SUPER       //              -- push super
+	OP_RETURN      //              -- return pop
+	OP_ITER        //              -- obj => iterator
+	OP_FOR_NEXT    // target       -- jump if top is done, else env.slots[0] = top.next()
+	OP_CLOSE_ITER  //              -- iterator => (close it)
+	OP_CLOSE_AT    // slot         -- close the iterator at stack[slot]
+	OP_CLOSE_QUIET //              -- iterator, err => err (close, ignoring errors)
+	OP_SETUP_TRY   // target       -- errors jump to target with the error pushed
+	OP_POP_TRY     //              -- remove the innermost handler
+	OP_CAUGHT      //              -- err => the value bound by catch
+	OP_RETHROW     //              -- raise pop
+)
+
+// operands is the number of operands each instruction takes.
+var operands = [...]int{
+	OP_CONST:       1,
+	OP_GET_VAR:     3,
+	OP_SET_VAR:     2,
+	OP_DEFINE:      1,
+	OP_GET_NAME:    2,
+	OP_SET_NAME:    2,
+	OP_DEFINE_NAME: 1,
+	OP_PUSH_ENV:    1,
+	OP_BINARY:      2,
+	OP_UNARY:       1,
+	OP_JUMP:        1,
+	OP_JUMP_FALSE:  1,
+	OP_AND:         1,
+	OP_OR:          1,
+	OP_GET_SLOT:    2,
+	OP_SET_SLOT:    1,
+	OP_GET_METHOD:  2,
+	OP_CALL_METHOD: 1,
+	OP_CALL:        1,
+	OP_ARRAY:       1,
+	OP_CLOSURE:     1,
+	OP_FOR_NEXT:    1,
+	OP_CLOSE_AT:    1,
+	OP_SETUP_TRY:   1,
+	OP_RETHROW:     0,
+}
+
+// Operands returns the number of operands taken by op.
+func (op Opcode) Operands() int { return operands[op] }
@@ -0,0 +1,116 @@
+// Package diag renders diagnostics (errors from the lexer, parser,
+// resolver, compiler and evaluator) together with the source lines they
+// refer to:
+//
+//	main.toe:2:9: not an expression: SEMICOLON
+//	    2 | let x = ;
+//	      |         ^
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Diagnostic is a message about some span of a source file.
+type Diagnostic struct {
+	Filename string
+	Line     int    // starting from 1.
+	Column   int    // starting from 1, in runes.
+	Length   int    // in runes; the span is underlined with at least one caret.
+	Kind     string // "lexer", "parser", "resolver", "compiler" or "runtime".
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.Filename, d.Line, d.Column, d.Message)
+}
+
+// Error is implemented by errors which refer to a position in a source
+// file, e.g. lexer.Error.
+type Error interface {
+	error
+	Diagnostic() Diagnostic
+}
+
+// Sources holds the contents of source files by filename, for showing
+// the lines referred to by diagnostics.
+type Sources map[string]string
+
+// Line returns the given line (starting from 1) of the file, without its
+// line ending.
+func (s Sources) Line(filename string, line int) (string, bool) {
+	source, ok := s[filename]
+	if !ok || line < 1 {
+		return "", false
+	}
+	for i := 1; i < line; i++ {
+		j := strings.IndexByte(source, '\n')
+		if j < 0 {
+			return "", false
+		}
+		source = source[j+1:]
+	}
+	if j := strings.IndexByte(source, '\n'); j >= 0 {
+		source = source[:j]
+	}
+	return strings.TrimSuffix(source, "\r"), true
+}
+
+// Snippet returns the source line of d, with carets under its span, e.g.
+//
+//	2 | let x = ;
+//	  |         ^
+//
+// Every line is prefixed by indent. If the line is not available, then
+// Snippet returns "".
+func (s Sources) Snippet(d Diagnostic, indent string) string {
+	line, ok := s.Line(d.Filename, d.Line)
+	if !ok || d.Column < 1 {
+		return ""
+	}
+	gutter := fmt.Sprintf("%d", d.Line)
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s%s | %s\n", indent, gutter, line)
+	fmt.Fprintf(&buf, "%s%s | ", indent, strings.Repeat(" ", len(gutter)))
+	// keep tabs, so that the carets line up with the source.
+	col := 1
+	for _, r := range line {
+		if col >= d.Column {
+			break
+		}
+		if r == '\t' {
+			buf.WriteByte('\t')
+		} else {
+			buf.WriteByte(' ')
+		}
+		col++
+	}
+	// the span ends at the end of the line.
+	length := d.Length
+	if rest := utf8.RuneCountInString(line) - col + 1; length > rest {
+		length = rest
+	}
+	if length < 1 {
+		length = 1
+	}
+	buf.WriteString(strings.Repeat("^", length))
+	return buf.String()
+}
+
+// Render returns err as `file:line:col: message', followed by the snippet
+// of the source it refers to, if err is a diag.Error and its source is
+// available. Other errors are rendered by their Error method.
+func (s Sources) Render(err error) string {
+	var de Error
+	if !errors.As(err, &de) {
+		return err.Error()
+	}
+	d := de.Diagnostic()
+	if snippet := s.Snippet(d, "    "); snippet != "" {
+		return d.String() + "\n" + snippet
+	}
+	return d.String()
+}
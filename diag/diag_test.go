@@ -0,0 +1,151 @@
+package diag_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"toe/diag"
+)
+
+var sources = diag.Sources{
+	"main.toe": "let x = 1;\r\n\tlet y = \"héllo\" + x;\nlast",
+	"empty":    "",
+}
+
+func TestLine(t *testing.T) {
+	tests := []struct {
+		filename string
+		line     int
+		expected string
+		ok       bool
+	}{
+		{"main.toe", 1, "let x = 1;", true},
+		{"main.toe", 2, "\tlet y = \"héllo\" + x;", true},
+		{"main.toe", 3, "last", true},
+		{"main.toe", 4, "", false},
+		{"main.toe", 0, "", false},
+		{"empty", 1, "", true},
+		{"missing.toe", 1, "", false},
+	}
+	for _, test := range tests {
+		line, ok := sources.Line(test.filename, test.line)
+		if line != test.expected || ok != test.ok {
+			t.Errorf("Line(%q, %d): expected %q, %t, got=%q, %t", test.filename, test.line, test.expected, test.ok, line, ok)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		d        diag.Diagnostic
+		expected string
+	}{
+		{
+			diag.Diagnostic{Filename: "main.toe", Line: 1, Column: 5, Length: 1},
+			"  1 | let x = 1;\n" +
+				"    |     ^",
+		},
+		{
+			// tabs are kept, and columns count runes.
+			diag.Diagnostic{Filename: "main.toe", Line: 2, Column: 19, Length: 1},
+			"  2 | \tlet y = \"héllo\" + x;\n" +
+				"    | \t                 ^",
+		},
+		{
+			diag.Diagnostic{Filename: "main.toe", Line: 2, Column: 10, Length: 7},
+			"  2 | \tlet y = \"héllo\" + x;\n" +
+				"    | \t        ^^^^^^^",
+		},
+		{
+			// spans are cut off at the end of the line.
+			diag.Diagnostic{Filename: "main.toe", Line: 3, Column: 3, Length: 10},
+			"  3 | last\n" +
+				"    |   ^^",
+		},
+		{
+			// at least one caret is shown, even past the end of the line.
+			diag.Diagnostic{Filename: "main.toe", Line: 3, Column: 5, Length: 0},
+			"  3 | last\n" +
+				"    |     ^",
+		},
+		{diag.Diagnostic{Filename: "missing.toe", Line: 1, Column: 1}, ""},
+		{diag.Diagnostic{Filename: "main.toe", Line: 0, Column: 0}, ""},
+	}
+	for i, test := range tests {
+		if got := sources.Snippet(test.d, "  "); got != test.expected {
+			t.Errorf("tests[%d]: expected\n%s\ngot=\n%s", i, test.expected, got)
+		}
+	}
+}
+
+type testError struct{ d diag.Diagnostic }
+
+func (e testError) Error() string               { return e.d.String() }
+func (e testError) Diagnostic() diag.Diagnostic { return e.d }
+
+func TestRender(t *testing.T) {
+	err := testError{diag.Diagnostic{Filename: "main.toe", Line: 1, Column: 9, Length: 1, Message: "bad"}}
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err, "main.toe:1:9: bad\n    1 | let x = 1;\n      |         ^"},
+		{fmt.Errorf("wrapped: %w", err), "main.toe:1:9: bad\n    1 | let x = 1;\n      |         ^"},
+		{testError{diag.Diagnostic{Filename: "missing.toe", Line: 1, Column: 1, Message: "bad"}}, "missing.toe:1:1: bad"},
+		{errors.New("plain"), "plain"},
+	}
+	for i, test := range tests {
+		if got := sources.Render(test.err); got != test.expected {
+			t.Errorf("tests[%d]: expected\n%s\ngot=\n%s", i, test.expected, got)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	names := []string{"name", "names", "size", "to_string", "x", "inspect"}
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"nmae", "name"},  // swapped characters.
+		{"nam", "name"},   // missing character.
+		{"sizes", "size"}, // extra character.
+		{"tostring", "to_string"},
+		{"insepct", "inspect"},
+		{"name", "names"}, // exact matches are not suggestions.
+		{"y", ""},         // single characters differ everywhere.
+		{"zzzz", ""},
+		{"nosuchslot", ""},
+	}
+	for _, test := range tests {
+		if got := diag.Suggest(test.name, names); got != test.expected {
+			t.Errorf("Suggest(%q): expected %q, got=%q", test.name, test.expected, got)
+		}
+	}
+	if got := diag.Suggest("cat", []string{"cab", "bat"}); got != "bat" {
+		t.Errorf("expected ties to pick the smallest name, got=%q", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	d := diag.Diagnostic{Filename: "main.toe", Line: 2, Column: 9, Length: 3, Kind: "parser", Message: "bad"}
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{testError{d}, `{"file":"main.toe","start":{"line":2,"column":9},"end":{"line":2,"column":12},"severity":"error","code":"parse-error","kind":"parser","message":"bad"}`},
+		{fmt.Errorf("wrapped: %w", testError{d}), `{"file":"main.toe","start":{"line":2,"column":9},"end":{"line":2,"column":12},"severity":"error","code":"parse-error","kind":"parser","message":"bad"}`},
+		{testError{diag.Diagnostic{Filename: "main.toe", Line: 1, Column: 1, Message: "empty"}}, `{"file":"main.toe","start":{"line":1,"column":1},"end":{"line":1,"column":2},"severity":"error","message":"empty"}`},
+		{errors.New("plain"), `{"severity":"error","message":"plain"}`},
+	}
+	for i, test := range tests {
+		b, err := json.Marshal(diag.NewReport(test.err))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.expected {
+			t.Errorf("tests[%d]: expected\n%s\ngot=\n%s", i, test.expected, b)
+		}
+	}
+}
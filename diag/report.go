@@ -0,0 +1,88 @@
+package diag
+
+import "errors"
+
+// Position is a position in a source file.
+type Position struct {
+	Line   int `json:"line"`   // starting from 1.
+	Column int `json:"column"` // starting from 1, in runes.
+}
+
+// Report is the machine-readable form of an error, for editors and CI.
+// It is encoded as JSON like so:
+//
+//	{"file": "main.toe", "start": {"line": 2, "column": 9},
+//	 "end": {"line": 2, "column": 10}, "severity": "error",
+//	 "code": "parse-error", "kind": "parser",
+//	 "message": "not an expression: SEMICOLON"}
+//
+// The span is that of the token the error refers to; for runtime errors,
+// the token of the operation which failed. Spans across lines are cut
+// down to their first character. The position fields are left out for
+// errors which do not refer to a position in a source file.
+type Report struct {
+	File     string    `json:"file,omitempty"`
+	Start    *Position `json:"start,omitempty"`
+	End      *Position `json:"end,omitempty"`  // just after the span.
+	Severity string    `json:"severity"`       // always "error", for now.
+	Code     string    `json:"code,omitempty"` // one of the Code constants.
+	Kind     string    `json:"kind,omitempty"` // see Diagnostic.Kind.
+	Message  string    `json:"message"`
+	Frames   []Frame   `json:"frames,omitempty"` // for runtime errors.
+}
+
+// The codes of reports, one for each class of error. Unlike messages,
+// they don't change between versions, so tools can match on them. The
+// code is left out for errors of other kinds, e.g. unreadable files.
+const (
+	CodeLex     = "lex-error"
+	CodeParse   = "parse-error"
+	CodeResolve = "resolve-error"
+	CodeCompile = "compile-error"
+	CodeRuntime = "runtime-error"
+	CodeAbort   = "aborted" // see eval.Error.Aborted.
+)
+
+// codes maps Diagnostic.Kind to the code of the report.
+var codes = map[string]string{
+	"lexer":    CodeLex,
+	"parser":   CodeParse,
+	"resolver": CodeResolve,
+	"compiler": CodeCompile,
+	"runtime":  CodeRuntime,
+}
+
+// Frame is a frame of the stack of a runtime error.
+type Frame struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Name   string `json:"name"` // e.g. [Module] or the function name.
+}
+
+// Report returns the report of d.
+func (d Diagnostic) Report() Report {
+	length := d.Length
+	if length < 1 {
+		length = 1
+	}
+	return Report{
+		File:     d.Filename,
+		Start:    &Position{d.Line, d.Column},
+		End:      &Position{d.Line, d.Column + length},
+		Severity: "error",
+		Code:     codes[d.Kind],
+		Kind:     d.Kind,
+		Message:  d.Message,
+	}
+}
+
+// NewReport returns the report of err, using its Diagnostic if it is a
+// diag.Error.
+func NewReport(err error) Report {
+	var de Error
+	if errors.As(err, &de) {
+		return de.Diagnostic().Report()
+	}
+	return Report{Severity: "error", Message: err.Error()}
+}
@@ -0,0 +1,65 @@
+package diag
+
+// Suggest returns the candidate closest to name, for "did you mean"
+// hints, or "" if none of them are close enough. Names are compared by
+// their edit distance, counting a swap of adjacent characters as one
+// edit; ties are broken by picking the smallest candidate.
+func Suggest(name string, candidates []string) string {
+	limit := len([]rune(name)) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	best, bestDist := "", limit+1
+	for _, c := range candidates {
+		if c == name {
+			continue
+		}
+		d := distance(name, c)
+		// names which differ everywhere are not typos of each other.
+		if d >= len([]rune(name)) || d >= len([]rune(c)) {
+			continue
+		}
+		if d < bestDist || (d == bestDist && c < best) {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// distance returns the optimal string alignment distance between a and
+// b: the number of insertions, deletions, substitutions and swaps of
+// adjacent runes needed to turn a into b.
+func distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// rows i-2, i-1 and i of the table.
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(t)]
+}
+
+func min(x int, rest ...int) int {
+	for _, y := range rest {
+		if y < x {
+			x = y
+		}
+	}
+	return x
+}
@@ -0,0 +1,216 @@
+package eval
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"toe/diag"
+)
+
+// =============
+// Embedding API
+// =============
+//
+// A Go program embeds toe by creating a Context, defining its own globals
+// (usually builtins wrapping Go functions), and running some source:
+//
+//      ctx := eval.NewContext()
+//      ctx.SetGlobal("double", eval.NewMethod("double",
+//          eval.ArgSpec{eval.VT_ANY, []eval.Arg{{"x", eval.VT_NUMBER}}},
+//          func(ctx *eval.Context, this eval.Value, args []eval.Value) eval.Value {
+//              return args[0].(eval.Number) * 2
+//          }))
+//      exports, err := ctx.Run("config.toe", `exports.x = double(21);`)
+//
+// Values cross the boundary as they are: Nil, Boolean, Number and String
+// are plain Go values, and everything else is an *Object, *Function or
+// *Builtin. Errors are returned as *Error, which implements error; this
+// includes Go panics in builtins, which are recovered.
+//
+// A Context is not safe for concurrent use.
+
+// SourceErrors holds the errors found while lexing, parsing, resolving or
+// compiling some source, before it could run.
+type SourceErrors []error
+
+func (e SourceErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Run evaluates source as a module named filename, returning its exports.
+// The error is either SourceErrors (from the lexer, parser, resolver or
+// compiler) or an *Error thrown by the module.
+func (ctx *Context) Run(filename string, source string) (Value, error) {
+	module, errs := ctx.parseModule(filename, source)
+	if len(errs) != 0 {
+		return nil, SourceErrors(errs)
+	}
+	old_env := ctx.env
+	ctx.env = nil
+	exports, err := ctx.runModule(module)
+	ctx.env = old_env
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// RunFile is like Run, but reads the source from the given file.
+func (ctx *Context) RunFile(filename string) (Value, error) {
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Run(filename, string(source))
+}
+
+// FormatError returns err (e.g. from Run) for display. Unlike err.Error(),
+// it shows the source lines referred to by the error, and for runtime
+// errors, by each frame of the stack.
+func (ctx *Context) FormatError(err error) string {
+	switch err := err.(type) {
+	case SourceErrors:
+		msgs := make([]string, len(err))
+		for i, e := range err {
+			msgs[i] = ctx.sources.Render(e)
+		}
+		return strings.Join(msgs, "\n")
+	case *Error:
+		return err.Render(ctx.sources)
+	}
+	return ctx.sources.Render(err)
+}
+
+// Reports returns err (e.g. from Run) in machine-readable form, with one
+// report for each error. Encoded as JSON, they are suitable for editors
+// and CI.
+func (ctx *Context) Reports(err error) []diag.Report {
+	switch err := err.(type) {
+	case SourceErrors:
+		reports := make([]diag.Report, len(err))
+		for i, e := range err {
+			reports[i] = diag.NewReport(e)
+		}
+		return reports
+	case *Error:
+		return []diag.Report{err.Report()}
+	}
+	return []diag.Report{diag.NewReport(err)}
+}
+
+// SetGlobal defines a global visible to every module run afterwards,
+// replacing any existing global with the same name (including builtins).
+func (ctx *Context) SetGlobal(name string, value Value) {
+	ctx.globals.values[name] = value
+}
+
+// Global returns the global with the given name, or nil if there is none.
+func (ctx *Context) Global(name string) Value {
+	return ctx.globals.values[name]
+}
+
+// Call calls fn (a *Function or *Builtin) with the given arguments,
+// and `this' set to nil.
+func (ctx *Context) Call(fn Value, args ...Value) (Value, error) {
+	return ctx.hostCall(func() Value {
+		return ctx.call(nil, fn, NIL, args)
+	})
+}
+
+// CallMethod calls the method obj.name with the given arguments.
+func (ctx *Context) CallMethod(obj Value, name string, args ...Value) (Value, error) {
+	return ctx.hostCall(func() Value {
+		return ctx.call_method(obj, name, args)
+	})
+}
+
+// GetSlot returns obj.name, searching the prototype chain.
+func (ctx *Context) GetSlot(obj Value, name string) (Value, error) {
+	rv := ctx.getSlot(obj, name, nil)
+	if isError(rv) {
+		return nil, rv.(*Error)
+	}
+	return rv, nil
+}
+
+// SetSlot sets obj.name to value. Only objects and functions have slots.
+func (ctx *Context) SetSlot(obj Value, name string, value Value) error {
+	if rv := ctx.setSlot(obj, name, value); isError(rv) {
+		return rv.(*Error)
+	}
+	return nil
+}
+
+// Inspect returns the result of v.inspect().
+func (ctx *Context) Inspect(v Value) (string, error) {
+	rv, err := ctx.hostCall(func() Value { return ctx.inspectValue(v) })
+	if err != nil {
+		return "", err
+	}
+	return string(rv.(String)), nil
+}
+
+// NewArray returns a new Array holding values.
+func (ctx *Context) NewArray(values ...Value) *Object {
+	return newArray(ctx, values)
+}
+
+// NewHash returns a new, empty Hash. Use its set method to fill it.
+func (ctx *Context) NewHash() *Object {
+	return newHash(ctx)
+}
+
+// NewObject returns a new object with the given prototype, like
+// proto.clone(). If proto is nil, then Object is used.
+func (ctx *Context) NewObject(proto Value) *Object {
+	if proto == nil {
+		proto = ctx.globals.Object
+	}
+	return newObject(proto)
+}
+
+// NewError returns an error with the given reason, which builtins can
+// return to throw it.
+func (ctx *Context) NewError(reason Value) *Error {
+	return newError(ctx, reason)
+}
+
+// Errorf is like NewError, with a formatted string as the reason.
+func (ctx *Context) Errorf(format string, a ...interface{}) *Error {
+	return newError(ctx, String(fmt.Sprintf(format, a...)))
+}
+
+// NewBuiltin returns a builtin which calls fn.
+func NewBuiltin(name string, fn BuiltinFunc) *Builtin {
+	return newBuiltin(name, fn)
+}
+
+// NewMethod returns a builtin which checks its receiver and arguments
+// against spec before calling fn, throwing an error if they don't match.
+func NewMethod(name string, spec ArgSpec, fn BuiltinFunc) *Builtin {
+	return newBuiltin(name, make_method(spec, fn))
+}
+
+// hostCall runs f on behalf of the host program. If nothing is running,
+// then the call stack gets a frame for the host, since builtins and error
+// stacks expect there to be one.
+func (ctx *Context) hostCall(f func() Value) (Value, error) {
+	if len(ctx.stack) == 0 {
+		ctx.pushFunc(hostCse{})
+		defer ctx.popFunc()
+	}
+	rv := f()
+	if isError(rv) {
+		return nil, rv.(*Error)
+	}
+	return rv, nil
+}
+
+type hostCse struct{}
+
+func (h hostCse) Filename() string { return "<host>" }
+func (h hostCse) Context() string  { return "[Host]" }
@@ -0,0 +1,335 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"toe/diag"
+)
+
+func TestEmbedding(t *testing.T) {
+	source := `
+exports.doubled = double(21);
+exports.add = fn(a, b) { return a + b; };
+exports.point = Point.new(1, 2);
+exports.is_point = fn(p) { return is_a(p, Point); };
+exports.bool = Boolean;
+`
+	for _, compile := range []bool{false, true} {
+		ctx := NewContext()
+		ctx.UseCompiler(compile)
+		double := NewMethod("double",
+			ArgSpec{VT_ANY, []Arg{{"x", VT_NUMBER}}},
+			func(ctx *Context, this Value, args []Value) Value {
+				return args[0].(Number) * 2
+			},
+		)
+		point := ctx.NewObject(nil)
+		if err := ctx.SetSlot(point, "init", NewBuiltin("init", func(ctx *Context, this Value, args []Value) Value {
+			ctx.setSlot(this, "coords", ctx.NewArray(args...))
+			return NIL
+		})); err != nil {
+			t.Fatal(err)
+		}
+		ctx.SetGlobal("double", double)
+		ctx.SetGlobal("Point", point)
+		if ctx.Global("double") != double || ctx.Global("Object") != ctx.globals.Object {
+			t.Errorf("expected Global to return globals")
+		}
+
+		exports, err := ctx.Run("<test>", source)
+		if err != nil {
+			t.Fatalf("compile=%t: unexpected error: %s", compile, err)
+		}
+		if v, _ := ctx.GetSlot(exports, "doubled"); v != Number(42) {
+			t.Errorf("compile=%t: expected doubled=42, got=%#v", compile, v)
+		}
+		add, _ := ctx.GetSlot(exports, "add")
+		if v, err := ctx.Call(add, Number(1), Number(2)); err != nil || v != Number(3) {
+			t.Errorf("compile=%t: expected add(1, 2)=3, got=%#v, %v", compile, v, err)
+		}
+		p, _ := ctx.GetSlot(exports, "point")
+		if s, err := ctx.Inspect(ctx.maybeGetSlot(p, "coords", nil)); err != nil || s != "[1, 2]" {
+			t.Errorf("compile=%t: expected point.coords=[1, 2], got=%q, %v", compile, s, err)
+		}
+		is_point, _ := ctx.GetSlot(exports, "is_point")
+		if v, _ := ctx.Call(is_point, ctx.NewObject(point)); v != TRUE {
+			t.Errorf("compile=%t: expected is_point(...)=true, got=%#v", compile, v)
+		}
+		if v, _ := ctx.GetSlot(exports, "bool"); v != ctx.globals.Boolean {
+			t.Errorf("compile=%t: expected bool=Boolean, got=%#v", compile, v)
+		}
+		if len(ctx.stack) != 0 || ctx.env != nil {
+			t.Errorf("compile=%t: expected the stack and env to be unwound", compile)
+		}
+	}
+}
+
+func TestEmbeddingErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetGlobal("double", NewMethod("double",
+		ArgSpec{VT_ANY, []Arg{{"x", VT_NUMBER}}},
+		func(ctx *Context, this Value, args []Value) Value {
+			return args[0].(Number) * 2
+		},
+	))
+	ctx.SetGlobal("fail", NewBuiltin("fail", func(ctx *Context, this Value, args []Value) Value {
+		return ctx.Errorf("failed with %d argument(s)", len(args))
+	}))
+	ctx.SetGlobal("crash", NewBuiltin("crash", func(ctx *Context, this Value, args []Value) Value {
+		if len(args) > 0 {
+			ctx.Call(args[0])
+		}
+		var values []Value
+		return values[len(args)]
+	}))
+
+	if _, err := ctx.Run("<test>", "let x = ;"); err == nil {
+		t.Errorf("expected a syntax error")
+	} else if _, ok := err.(SourceErrors); !ok {
+		t.Errorf("expected SourceErrors, got=%#v", err)
+	}
+	if _, err := ctx.Run("<test>", "undefined;"); err == nil {
+		t.Errorf("expected a resolver error")
+	}
+
+	tests := []struct {
+		source string
+		reason string
+	}{
+		{`double("x");`, "argument 'x' has no VT_NUMBER in prototype chain"},
+		{`double();`, "expected 1 argument(s), got=0"},
+		{`fail(1, 2);`, "failed with 2 argument(s)"},
+		{`crash();`, "crash panicked: runtime error: index out of range [0] with length 0"},
+		{`crash(crash);`, "crash panicked: runtime error: index out of range [1] with length 0"},
+		{`let o = Object.clone(); o.to_string = crash; "{}".format(o);`, "crash panicked: runtime error: index out of range [0] with length 0"},
+	}
+	for _, test := range tests {
+		_, err := ctx.Run("<test>", test.source)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected an *Error, got=%#v", test.source, err)
+			continue
+		}
+		if e.Reason() != String(test.reason) {
+			t.Errorf("%s: expected reason %q, got=%#v", test.source, test.reason, e.Reason())
+		}
+		if !strings.HasPrefix(e.Error(), "Error: ") {
+			t.Errorf("%s: expected Error() to be the same as String(), got=%q", test.source, e.Error())
+		}
+	}
+
+	if _, err := ctx.CallMethod(ctx.NewHash(), "missing"); err == nil {
+		t.Errorf("expected an error calling a missing method")
+	}
+	if _, err := ctx.Call(Number(1)); err == nil {
+		t.Errorf("expected an error calling a number")
+	}
+	if err := ctx.SetSlot(Number(1), "x", NIL); err == nil {
+		t.Errorf("expected an error setting a slot on a number")
+	}
+	if len(ctx.stack) != 0 {
+		t.Errorf("expected an empty stack, got=%d entries", len(ctx.stack))
+	}
+}
+
+func TestStreams(t *testing.T) {
+	for _, compile := range []bool{false, true} {
+		var stdout, stderr bytes.Buffer
+		ctx := NewContext()
+		ctx.UseCompiler(compile)
+		ctx.SetStdout(&stdout)
+		ctx.SetStderr(&stderr)
+		ctx.SetStdin(strings.NewReader("alice\r\nbob"))
+		_, err := ctx.Run("<test>", `
+let name = input("name? ");
+while (name) {
+	print("hello", name);
+	eprint("read", name.size());
+	name = input();
+}
+puts("done");
+`)
+		if err != nil {
+			t.Fatalf("compile=%t: unexpected error: %s", compile, err)
+		}
+		if expected := "name? hello alice\nhello bob\ndone\n"; stdout.String() != expected {
+			t.Errorf("compile=%t: expected stdout %q, got=%q", compile, expected, stdout.String())
+		}
+		if expected := "read 5\nread 3\n"; stderr.String() != expected {
+			t.Errorf("compile=%t: expected stderr %q, got=%q", compile, expected, stderr.String())
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	for _, compile := range []bool{false, true} {
+		ctx := NewContext()
+		ctx.UseCompiler(compile)
+		_, err := ctx.Run("<test>", "let f = fn(x) {\n\treturn x.y;\n};\nf(nil);\n")
+		expected := `Error: "object has no slot \"y\""
+  at <test>:2:11: f
+    2 | 	return x.y;
+      | 	         ^
+  at <test>:4:2: [Module]
+    4 | f(nil);
+      |  ^`
+		if got := ctx.FormatError(err); got != expected {
+			t.Errorf("compile=%t: expected\n%s\ngot=\n%s", compile, expected, got)
+		}
+	}
+
+	ctx := NewContext()
+	_, err := ctx.Run("<test>", "let x = ;\nlet y = z;")
+	expected := `<test>:1:9: not an expression: SEMICOLON
+    1 | let x = ;
+      |         ^`
+	if got := ctx.FormatError(err); got != expected {
+		t.Errorf("expected\n%s\ngot=\n%s", expected, got)
+	}
+	_, err = ctx.Run("<test>", "let y = zzz;")
+	expected = `<test>:1:9: undefined variable "zzz"
+    1 | let y = zzz;
+      |         ^^^`
+	if got := ctx.FormatError(err); got != expected {
+		t.Errorf("expected\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestInteractiveFormatError(t *testing.T) {
+	ic := NewInteractiveContext()
+	if _, errs := ic.Run("let f = fn() {\n  return nil.x;\n};"); errs != nil {
+		t.Fatal(errs)
+	}
+	if _, errs := ic.Run("let = 1;"); errs == nil {
+		t.Fatal("expected a syntax error")
+	} else if got, expected := ic.FormatError(SourceErrors(errs)), "<stdin>:4:5: expect an identifier\n    4 | let = 1;\n      |     ^"; got != expected {
+		t.Errorf("expected\n%s\ngot=\n%s", expected, got)
+	}
+	// lines are numbered across inputs, so frames from earlier inputs
+	// still show the right lines.
+	rv, errs := ic.Run("1;\nf();")
+	if errs != nil || !isError(rv) {
+		t.Fatalf("expected a runtime error, got=%#v, %v", rv, errs)
+	}
+	expected := `Error: "object has no slot \"x\""
+  at <stdin>:2:14: f
+    2 |   return nil.x;
+      |              ^
+  at <stdin>:6:2: [Module]
+    6 | f();
+      |  ^`
+	if got := ic.FormatError(rv.(*Error)); got != expected {
+		t.Errorf("expected\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestInteractiveRun(t *testing.T) {
+	for _, compile := range []bool{false, true} {
+		ic := NewInteractiveContext()
+		ic.ctx.UseCompiler(compile)
+		tests := []struct {
+			input    string
+			expected Value
+		}{
+			{"", nil},
+			{"let x = 20;", NIL},
+			{"x + 1;\nx * 2;", Number(40)},
+			{"if (x > 1) { x; } else { 0; }", Number(20)},
+			{"let f = fn() { return x; };", NIL},
+			{"f() + 2;", Number(22)},
+		}
+		for _, test := range tests {
+			rv, errs := ic.Run(test.input)
+			if errs != nil || rv != test.expected {
+				t.Errorf("compile=%t: %q: expected %#v, got=%#v, %v", compile, test.input, test.expected, rv, errs)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	// the jump over the body does not fit in an operand.
+	source := "let x = false;\nif (x) {\n" + strings.Repeat("x;\n", 20000) + "}\n"
+	ctx := NewContext()
+	_, err := ctx.Run("main.toe", source)
+	errs, ok := err.(SourceErrors)
+	if !ok || len(errs) == 0 {
+		t.Fatalf("expected SourceErrors, got=%#v", err)
+	}
+	if r := ctx.Reports(err); r[0].Kind != "compiler" || r[0].Message != "function is too large to compile" {
+		t.Errorf("expected a compiler report, got=%+v", r[0])
+	}
+	ctx.UseCompiler(false)
+	if _, err := ctx.Run("main.toe", source); err != nil {
+		t.Errorf("expected the evaluator to run it, got=%s", err)
+	}
+}
+
+func TestReports(t *testing.T) {
+	for _, compile := range []bool{false, true} {
+		ctx := NewContext()
+		ctx.UseCompiler(compile)
+		_, err := ctx.Run("main.toe", "let f = fn(x) {\n\treturn x.yyy;\n};\nf(nil);\n")
+		b, _ := json.Marshal(ctx.Reports(err))
+		expected := `[{"file":"main.toe","start":{"line":2,"column":11},"end":{"line":2,"column":14},"severity":"error","code":"runtime-error","kind":"runtime","message":"object has no slot \"yyy\"",` +
+			`"frames":[{"file":"main.toe","line":2,"column":11,"name":"f"},{"file":"main.toe","line":4,"column":2,"name":"[Module]"}]}]`
+		if string(b) != expected {
+			t.Errorf("compile=%t: expected\n%s\ngot=\n%s", compile, expected, b)
+		}
+	}
+
+	ctx := NewContext()
+	_, err := ctx.Run("main.toe", "let x = ;\nlet = 1;")
+	b, _ := json.Marshal(ctx.Reports(err))
+	expected := `[{"file":"main.toe","start":{"line":1,"column":9},"end":{"line":1,"column":10},"severity":"error","code":"parse-error","kind":"parser","message":"not an expression: SEMICOLON"},` +
+		`{"file":"main.toe","start":{"line":2,"column":5},"end":{"line":2,"column":6},"severity":"error","code":"parse-error","kind":"parser","message":"expect an identifier"}]`
+	if string(b) != expected {
+		t.Errorf("expected\n%s\ngot=\n%s", expected, b)
+	}
+	_, err = ctx.Run("main.toe", "\"abc")
+	if r := ctx.Reports(err); len(r) != 1 || r[0].Kind != "lexer" || r[0].Code != diag.CodeLex {
+		t.Errorf("expected a lexer report, got=%+v", r)
+	}
+	_, err = ctx.Run("main.toe", "zzz;")
+	if r := ctx.Reports(err); len(r) != 1 || r[0].Code != diag.CodeResolve || r[0].End.Column != 4 {
+		t.Errorf("expected a resolver report ending at column 4, got=%+v", r)
+	}
+	_, err = ctx.Run("main.toe", "let E = Error.clone();\nE.inspect = fn() { return \"E\"; };\nE.new().throw();")
+	if r := ctx.Reports(err); len(r) != 1 || r[0].Message != "E" {
+		t.Errorf("expected non-string reasons to be inspected, got=%+v", r)
+	}
+	ctx.SetStepLimit(100)
+	_, err = ctx.Run("main.toe", "while (true) {}")
+	if r := ctx.Reports(err); len(r) != 1 || r[0].Code != diag.CodeAbort || r[0].Kind != "runtime" {
+		t.Errorf("expected an abort report, got=%+v", r)
+	}
+}
+
+func TestInspectGlobals(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetArgs([]string{"x", "y"})
+	config := ctx.NewObject(nil)
+	ctx.SetSlot(config, "debug", TRUE)
+	ctx.SetGlobal("config", config)
+	ctx.SetGlobal("names", ctx.NewArray(String("a")))
+	exports, err := ctx.Run("<test>", `
+exports.argv = ARGV.inspect();
+exports.config = config.inspect();
+exports.names = [names, Array].inspect();
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"argv":   `["x", "y"]`,
+		"config": `Object {debug: true}`,
+		"names":  `[["a"], Array]`,
+	}
+	for name, expected := range tests {
+		if v, _ := ctx.GetSlot(exports, name); v != String(expected) {
+			t.Errorf("%s: expected %q, got=%#v", name, expected, v)
+		}
+	}
+}
@@ -1,8 +1,16 @@
 package eval
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"toe/resolver"
+	"unicode/utf8"
 )
 
 // =================
@@ -12,14 +20,102 @@ import (
 // ----
 // puts
 // ----
+// puts(...) writes each argument on its own line, see to_string().
 func bi_puts(ctx *Context, this Value, args []Value) Value {
 	for _, obj := range args {
-		// fmt.Println(obj.(Stringer).String())
-		fmt.Println(obj)
+		str := ctx.toString(obj)
+		if isError(str) {
+			return ctx.addErrorStackBuiltin(str.(*Error))
+		}
+		if _, err := fmt.Fprintln(ctx.stdout, string(str.(String))); err != nil {
+			return newError(ctx, String(err.Error()))
+		}
 	}
 	return NIL
 }
 
+// ------------
+// print/eprint
+// ------------
+// print(...) writes its arguments separated by spaces, and followed by
+// a newline. Like puts, arguments are converted using to_string().
+func bi_print(ctx *Context, this Value, args []Value) Value {
+	return printTo(ctx, ctx.stdout, args)
+}
+
+func bi_eprint(ctx *Context, this Value, args []Value) Value {
+	return printTo(ctx, ctx.stderr, args)
+}
+
+func printTo(ctx *Context, w io.Writer, args []Value) Value {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		str := ctx.toString(arg)
+		if isError(str) {
+			return ctx.addErrorStackBuiltin(str.(*Error))
+		}
+		parts[i] = string(str.(String))
+	}
+	if _, err := fmt.Fprintln(w, strings.Join(parts, " ")); err != nil {
+		return newError(ctx, String(err.Error()))
+	}
+	return NIL
+}
+
+// -----
+// input
+// -----
+// input(prompt?) writes the prompt (if any) to stdout, and returns the
+// next line read from stdin without its line ending, or nil at the end
+// of the input.
+func bi_input(ctx *Context, this Value, args []Value) Value {
+	if len(args) > 1 {
+		return newError(ctx, String(fmt.Sprintf("expected at most 1 argument(s), got=%d", len(args))))
+	}
+	if len(args) == 1 {
+		prompt, err := expectArgType(ctx, "prompt", args[0], VT_STRING)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(ctx.stdout, string(prompt.(String))); err != nil {
+			return newError(ctx, String(err.Error()))
+		}
+	}
+	if ctx.stdin == nil {
+		ctx.stdin = bufio.NewReader(os.Stdin)
+	}
+	line, err := ctx.stdin.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return NIL
+	}
+	if err != nil && err != io.EOF {
+		return newError(ctx, String(err.Error()))
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return String(line)
+}
+
+// -------
+// require
+// -------
+func bi_require(ctx *Context, this Value, args []Value) Value {
+	if err := expectNArgs(ctx, args, 1); err != nil {
+		return err
+	}
+	path, err := expectArgType(ctx, "path", args[0], VT_STRING)
+	if err != nil {
+		return err
+	}
+	// the entry below ours is whoever called require().
+	whence := ctx.stack[len(ctx.stack)-2].Filename()
+	rv := ctx.requireModule(whence, string(path.(String)))
+	if isError(rv) {
+		ctx.addErrorStackBuiltin(rv.(*Error))
+	}
+	return rv
+}
+
 // --------
 // get_slot
 // --------
@@ -57,7 +153,7 @@ func bi_slot_names(ctx *Context, this Value, args []Value) Value {
 	}
 	slots := []Value{}
 	if obj_slots, ok := args[0].(hasSlots); ok {
-		for slot := range obj_slots.getSlots() {
+		for _, slot := range obj_slots.getSlots().names() {
 			slots = append(slots, String(slot))
 		}
 	}
@@ -86,15 +182,7 @@ func bi_is_a(ctx *Context, this Value, args []Value) Value {
 	if err := expectNArgs(ctx, args, 2); err != nil {
 		return err
 	}
-	obj := args[0]
-	query := args[1]
-	for obj != nil {
-		if obj == query {
-			return TRUE
-		}
-		obj = ctx.getPrototype(obj)
-	}
-	return FALSE
+	return Boolean(ctx.isA(args[0], args[1]))
 }
 
 // ------
@@ -143,6 +231,11 @@ func bi_Object_neq(ctx *Context, this Value, args []Value) Value {
 	return Boolean(!isTruthy(rv))
 }
 
+// Objects are hashed by identity, which agrees with Object.==.
+func bi_Object_hash(ctx *Context, this Value, args []Value) Value {
+	return htHashIdentity(this)
+}
+
 // --------
 // Function
 // --------
@@ -178,6 +271,55 @@ func bi_Error_throw(ctx *Context, this Value, args []Value) Value {
 	return newError(ctx, this)
 }
 
+// --------
+// Iterator
+// --------
+
+var bi_Iterator_done = make_method(
+	make_argspec(VT_ITERATOR),
+	func(ctx *Context, this Value, args []Value) Value {
+		return this.(Iterator).Done()
+	},
+)
+
+var bi_Iterator_next = make_method(
+	make_argspec(VT_ITERATOR),
+	func(ctx *Context, this Value, args []Value) Value {
+		it := this.(Iterator)
+		done := it.Done()
+		if isError(done) {
+			return done
+		}
+		if isTruthy(done) {
+			return newError(ctx, String("next() called on a finished iterator"))
+		}
+		return it.Next()
+	},
+)
+
+var bi_Iterator_close = make_method(
+	make_argspec(VT_ITERATOR),
+	func(ctx *Context, this Value, args []Value) Value {
+		return this.(Iterator).Close()
+	},
+)
+
+// iterators are iterable themselves.
+func bi_Iterator_iter(ctx *Context, this Value, args []Value) Value {
+	return this
+}
+
+// -------
+// Boolean
+// -------
+
+var bi_Boolean_hash = make_method(
+	make_argspec(VT_BOOLEAN),
+	func(ctx *Context, this Value, args []Value) Value {
+		return this.(Boolean).Hash()
+	},
+)
+
 // ------
 // Number
 // ------
@@ -200,12 +342,116 @@ func bi_Number_leq(ctx *Context, left, right Value) Value {
 	return Boolean(left.(Number) <= right.(Number))
 }
 
+// a % b and a ~/ b are floored, so that a == (a ~/ b) * b + a % b
+// and the sign of a % b follows b.
+func bi_Number_modulo(ctx *Context, left, right Value) Value {
+	a, b := float64(left.(Number)), float64(right.(Number))
+	if b == 0 {
+		return newError(ctx, String("division by zero"))
+	}
+	return Number(a - b*math.Floor(a/b))
+}
+func bi_Number_floor_divide(ctx *Context, left, right Value) Value {
+	a, b := float64(left.(Number)), float64(right.(Number))
+	if b == 0 {
+		return newError(ctx, String("division by zero"))
+	}
+	return Number(math.Floor(a / b))
+}
+func bi_Number_power(ctx *Context, left, right Value) Value {
+	return Number(math.Pow(float64(left.(Number)), float64(right.(Number))))
+}
+
+// bitwise operators work on the int64 value of integral numbers.
+func bitOp(f func(ctx *Context, a, b int64) Value) binOpFunc {
+	return func(ctx *Context, left, right Value) Value {
+		a, ok1 := toInt64(left.(Number))
+		b, ok2 := toInt64(right.(Number))
+		if !ok1 || !ok2 {
+			return newError(ctx, String("bitwise operands must be integers"))
+		}
+		return f(ctx, a, b)
+	}
+}
+
+var bi_Number_and = bitOp(func(ctx *Context, a, b int64) Value { return Number(a & b) })
+var bi_Number_or = bitOp(func(ctx *Context, a, b int64) Value { return Number(a | b) })
+var bi_Number_xor = bitOp(func(ctx *Context, a, b int64) Value { return Number(a ^ b) })
+var bi_Number_lshift = bitOp(func(ctx *Context, a, b int64) Value {
+	if b < 0 {
+		return newError(ctx, String("negative shift count"))
+	}
+	return Number(a << uint64(b))
+})
+var bi_Number_rshift = bitOp(func(ctx *Context, a, b int64) Value {
+	if b < 0 {
+		return newError(ctx, String("negative shift count"))
+	}
+	return Number(a >> uint64(b))
+})
+
+func numberMethod(f func(float64) float64) BuiltinFunc {
+	return make_method(
+		make_argspec(VT_NUMBER),
+		func(ctx *Context, this Value, args []Value) Value {
+			return Number(f(float64(this.(Number))))
+		},
+	)
+}
+
+var bi_Number_floor = numberMethod(math.Floor)
+var bi_Number_ceil = numberMethod(math.Ceil)
+var bi_Number_round = numberMethod(math.Round)
+var bi_Number_abs = numberMethod(math.Abs)
+var bi_Number_sqrt = numberMethod(math.Sqrt)
+
+var bi_Number_is_nan = make_method(
+	make_argspec(VT_NUMBER),
+	func(ctx *Context, this Value, args []Value) Value {
+		return Boolean(math.IsNaN(float64(this.(Number))))
+	},
+)
+
+var bi_Number_is_integer = make_method(
+	make_argspec(VT_NUMBER),
+	func(ctx *Context, this Value, args []Value) Value {
+		_, ok := toInt64(this.(Number))
+		return Boolean(ok)
+	},
+)
+
+var bi_Number_to_string = make_method(
+	make_argspec(VT_NUMBER),
+	func(ctx *Context, this Value, args []Value) Value {
+		return String(formatNumber(this.(Number)))
+	},
+)
+
+var bi_Number_hash = make_method(
+	make_argspec(VT_NUMBER),
+	func(ctx *Context, this Value, args []Value) Value {
+		return this.(Number).Hash()
+	},
+)
+
 // ------
 // String
 // ------
 
 func bi_String_equal(ctx *Context, left, right Value) Value { return Boolean(left.(String) == right.(String)) }
-func bi_String_plus(ctx *Context, left, right Value) Value { return left.(String) + right.(String) }
+
+// bi_String_plus converts the right operand with to_string(), so that
+// e.g. "n = " + 1 works.
+func bi_String_plus(ctx *Context, left, right Value) Value {
+	if s, ok := right.(String); ok {
+		return left.(String) + s
+	}
+	str := ctx.toString(right)
+	if isError(str) {
+		return str
+	}
+	return left.(String) + str.(String)
+}
 func bi_String_gt(ctx *Context, left, right Value) Value {
 	return Boolean(left.(String) > right.(String))
 }
@@ -219,6 +465,270 @@ func bi_String_leq(ctx *Context, left, right Value) Value {
 	return Boolean(left.(String) <= right.(String))
 }
 
+// String methods are rune-aware: sizes and indices count runes, except
+// for byte_size and byte_at. Negative indices count from the end.
+
+var bi_String_size = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return Number(utf8.RuneCountInString(string(this.(String))))
+	},
+)
+
+var bi_String_byte_size = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return Number(len(this.(String)))
+	},
+)
+
+var bi_String_get = make_method(
+	make_argspec(VT_STRING, make_argpair("index", VT_NUMBER)),
+	func(ctx *Context, this Value, args []Value) Value {
+		runes := []rune(string(this.(String)))
+		idx, ok := normaliseIndex(args[0].(Number), len(runes))
+		if !ok {
+			return newError(ctx, String("string index out of bounds"))
+		}
+		return String(runes[idx])
+	},
+)
+
+var bi_String_byte_at = make_method(
+	make_argspec(VT_STRING, make_argpair("index", VT_NUMBER)),
+	func(ctx *Context, this Value, args []Value) Value {
+		str := this.(String)
+		idx, ok := normaliseIndex(args[0].(Number), len(str))
+		if !ok {
+			return newError(ctx, String("string index out of bounds"))
+		}
+		return Number(str[idx])
+	},
+)
+
+// s.slice(start, end?) returns the runes in [start, end), clamping
+// the indices to the string.
+func bi_String_slice(ctx *Context, this Value, args []Value) Value {
+	str, err := expectArgType(ctx, "this", this, VT_STRING)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		if err := expectNArgs(ctx, args, 1); err != nil {
+			return err
+		}
+	}
+	runes := []rune(string(str.(String)))
+	start, err := expectArgType(ctx, "start", args[0], VT_NUMBER)
+	if err != nil {
+		return err
+	}
+	end := Value(Number(len(runes)))
+	if len(args) == 2 && args[1] != NIL {
+		end, err = expectArgType(ctx, "end", args[1], VT_NUMBER)
+		if err != nil {
+			return err
+		}
+	}
+	lo := clampIndex(start.(Number), len(runes))
+	hi := clampIndex(end.(Number), len(runes))
+	if lo >= hi {
+		return String("")
+	}
+	return String(runes[lo:hi])
+}
+
+// s.find(sub) returns the rune index of the first occurrence of sub,
+// or -1 if sub is not found.
+var bi_String_find = make_method(
+	make_argspec(VT_STRING, make_argpair("sub", VT_STRING)),
+	func(ctx *Context, this Value, args []Value) Value {
+		str := string(this.(String))
+		idx := strings.Index(str, string(args[0].(String)))
+		if idx < 0 {
+			return Number(-1)
+		}
+		return Number(utf8.RuneCountInString(str[:idx]))
+	},
+)
+
+// s.split(sep?) splits s around sep; without a separator, s is split
+// around runs of whitespace.
+func bi_String_split(ctx *Context, this Value, args []Value) Value {
+	str, err := expectArgType(ctx, "this", this, VT_STRING)
+	if err != nil {
+		return err
+	}
+	var parts []string
+	if len(args) == 0 {
+		parts = strings.Fields(string(str.(String)))
+	} else {
+		if err := expectNArgs(ctx, args, 1); err != nil {
+			return err
+		}
+		sep, err := expectArgType(ctx, "sep", args[0], VT_STRING)
+		if err != nil {
+			return err
+		}
+		parts = strings.Split(string(str.(String)), string(sep.(String)))
+	}
+	values := make([]Value, len(parts))
+	for i, part := range parts {
+		values[i] = String(part)
+	}
+	return newArray(ctx, values)
+}
+
+// sep.join(arr) concatenates the elements of arr, converted using
+// to_string(), separated by sep.
+var bi_String_join = make_method(
+	make_argspec(VT_STRING, make_argpair("arr", VT_ARRAY)),
+	func(ctx *Context, this Value, args []Value) Value {
+		values := args[0].(*Array).values
+		parts := make([]string, len(values))
+		for i, v := range values {
+			str := ctx.toString(v)
+			if isError(str) {
+				return ctx.addErrorStackBuiltin(str.(*Error))
+			}
+			parts[i] = string(str.(String))
+		}
+		return String(strings.Join(parts, string(this.(String))))
+	},
+)
+
+var bi_String_replace = make_method(
+	make_argspec(VT_STRING, make_argpair("old", VT_STRING), make_argpair("new", VT_STRING)),
+	func(ctx *Context, this Value, args []Value) Value {
+		return String(strings.ReplaceAll(
+			string(this.(String)),
+			string(args[0].(String)),
+			string(args[1].(String)),
+		))
+	},
+)
+
+var bi_String_upper = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return String(strings.ToUpper(string(this.(String))))
+	},
+)
+
+var bi_String_lower = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return String(strings.ToLower(string(this.(String))))
+	},
+)
+
+var bi_String_trim = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return String(strings.TrimSpace(string(this.(String))))
+	},
+)
+
+var bi_String_starts_with = make_method(
+	make_argspec(VT_STRING, make_argpair("prefix", VT_STRING)),
+	func(ctx *Context, this Value, args []Value) Value {
+		return Boolean(strings.HasPrefix(string(this.(String)), string(args[0].(String))))
+	},
+)
+
+var bi_String_ends_with = make_method(
+	make_argspec(VT_STRING, make_argpair("suffix", VT_STRING)),
+	func(ctx *Context, this Value, args []Value) Value {
+		return Boolean(strings.HasSuffix(string(this.(String)), string(args[0].(String))))
+	},
+)
+
+// maxRepeatSize is the size in bytes of the largest string built by
+// repeat(), so that a script cannot exhaust the host's memory in one call.
+const maxRepeatSize = 1 << 28
+
+var bi_String_repeat = make_method(
+	make_argspec(VT_STRING, make_argpair("n", VT_NUMBER)),
+	func(ctx *Context, this Value, args []Value) Value {
+		str := string(this.(String))
+		n, ok := toInt64(args[0].(Number))
+		if !ok || n < 0 {
+			return newError(ctx, String("repeat count must be a non-negative integer"))
+		}
+		if n > 0 && int64(len(str)) > maxRepeatSize/n {
+			return newError(ctx, String("repeat count too large"))
+		}
+		return String(strings.Repeat(str, int(n)))
+	},
+)
+
+// s.format(args...) replaces each "{}" in s with the next argument,
+// converted using to_string(). Use "{{" and "}}" for literal braces.
+func bi_String_format(ctx *Context, this Value, args []Value) Value {
+	str, err := expectArgType(ctx, "this", this, VT_STRING)
+	if err != nil {
+		return err
+	}
+	format := string(str.(String))
+	var buf strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		ch := format[i]
+		switch {
+		case ch == '{' && i+1 < len(format) && format[i+1] == '{':
+			buf.WriteByte('{')
+			i++
+		case ch == '}' && i+1 < len(format) && format[i+1] == '}':
+			buf.WriteByte('}')
+			i++
+		case ch == '{' && i+1 < len(format) && format[i+1] == '}':
+			if next >= len(args) {
+				return newError(ctx, String("format: not enough arguments"))
+			}
+			s := ctx.toString(args[next])
+			if isError(s) {
+				ctx.addErrorStackBuiltin(s.(*Error))
+				return s
+			}
+			buf.WriteString(string(s.(String)))
+			next++
+			i++
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	if next != len(args) {
+		return newError(ctx, String("format: too many arguments"))
+	}
+	return String(buf.String())
+}
+
+var bi_String_to_number = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		str := strings.TrimSpace(string(this.(String)))
+		num, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return newError(ctx, String(fmt.Sprintf("invalid number: %q", str)))
+		}
+		return Number(num)
+	},
+)
+
+var bi_String_hash = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return this.(String).Hash()
+	},
+)
+
+var bi_String_iter = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return newIterator(ctx, &StringIterator{s: this.(String)})
+	},
+)
+
 // -----
 // Array
 // -----
@@ -281,7 +791,7 @@ var bi_Array_size = make_method(
 )
 
 var bi_Array_get = make_method(
-	make_argspec(VT_ARRAY, make_argpair("size", VT_NUMBER)),
+	make_argspec(VT_ARRAY, make_argpair("index", VT_NUMBER)),
 	func(ctx *Context, this Value, args []Value) Value {
 		arr := this.(*Array)
 		idx := int(args[0].(Number))
@@ -306,11 +816,29 @@ var bi_Array_set = make_method(
 )
 
 var bi_Array_push = make_method(
-	make_argspec(VT_ARRAY, make_argpair("value", VT_ARRAY)),
-	func (ctx *Context, this Value, args []Value) Value {
+	make_argspec(VT_ARRAY, make_argpair("value", VT_ANY)),
+	func(ctx *Context, this Value, args []Value) Value {
 		arr := this.(*Array)
 		arr.values = append(arr.values, args[0])
-		return nil
+		return NIL
+	},
+)
+
+var bi_Array_hash = make_method(
+	make_argspec(VT_ARRAY),
+	func(ctx *Context, this Value, args []Value) Value {
+		rv := ctx.htHashArray(this.(*Array).values)
+		if isError(rv) {
+			ctx.addErrorStackBuiltin(rv.(*Error))
+		}
+		return rv
+	},
+)
+
+var bi_Array_iter = make_method(
+	make_argspec(VT_ARRAY),
+	func(ctx *Context, this Value, args []Value) Value {
+		return newIterator(ctx, &ArrayIterator{a: this.(*Array)})
 	},
 )
 
@@ -322,7 +850,7 @@ func bi_Array_pop(ctx *Context, this Value, args []Value) Value {
 	me := arr.(*Array)
 	sz := len(me.values)
 	if sz == 0 {
-		return newError(ctx, String("pop from empty string"))
+		return newError(ctx, String("pop from empty array"))
 	}
 	idx := sz - 1
 	if len(args) > 0 {
@@ -336,7 +864,7 @@ func bi_Array_pop(ctx *Context, this Value, args []Value) Value {
 		}
 	}
 	rv := me.values[idx]
-	me.values = append(me.values[idx:], me.values[idx+1:]...)
+	me.values = append(me.values[:idx], me.values[idx+1:]...)
 	return rv
 }
 
@@ -359,19 +887,40 @@ func bi_Hash_get(ctx *Context, this Value, args []Value) Value {
 	if err != nil {
 		return err
 	}
-	if err := expectNArgs(ctx, args, 1); err != nil {
-		return err
+	if len(args) != 2 {
+		if err := expectNArgs(ctx, args, 1); err != nil {
+			return err
+		}
 	}
 	rv, found, err := hash.(*Hash).table.get(args[0])
 	if err != nil {
 		return err
 	}
 	if !found {
+		// h.get(key, default)
+		if len(args) == 2 {
+			return args[1]
+		}
 		return newError(ctx, String("key not in hash"))
 	}
 	return rv
 }
 
+func bi_Hash_has(ctx *Context, this Value, args []Value) Value {
+	hash, err := expectArgType(ctx, "this", this, VT_HASH)
+	if err != nil {
+		return err
+	}
+	if err := expectNArgs(ctx, args, 1); err != nil {
+		return err
+	}
+	_, found, err := hash.(*Hash).table.get(args[0])
+	if err != nil {
+		return err
+	}
+	return Boolean(found)
+}
+
 func bi_Hash_set(ctx *Context, this Value, args []Value) Value {
 	hash, err := expectArgType(ctx, "this", this, VT_HASH)
 	if err != nil {
@@ -412,6 +961,67 @@ func bi_Hash_size(ctx *Context, this Value, args []Value) Value {
 	return Number(hash.(*Hash).table.size())
 }
 
+// Hashes are mutable and compared by their contents, so they
+// cannot be used as keys.
+func bi_Hash_hash(ctx *Context, this Value, args []Value) Value {
+	return newError(ctx, String("object Hash is not hashable"))
+}
+
+var bi_Hash_clear = make_method(
+	make_argspec(VT_HASH),
+	func(ctx *Context, this Value, args []Value) Value {
+		this.(*Hash).table.clear()
+		return NIL
+	},
+)
+
+var bi_Hash_keys = make_method(
+	make_argspec(VT_HASH),
+	func(ctx *Context, this Value, args []Value) Value {
+		keys := []Value{}
+		for _, entry := range this.(*Hash).table.entries {
+			if entry.hasValue() {
+				keys = append(keys, *entry.key)
+			}
+		}
+		return newArray(ctx, keys)
+	},
+)
+
+var bi_Hash_values = make_method(
+	make_argspec(VT_HASH),
+	func(ctx *Context, this Value, args []Value) Value {
+		values := []Value{}
+		for _, entry := range this.(*Hash).table.entries {
+			if entry.hasValue() {
+				values = append(values, *entry.value)
+			}
+		}
+		return newArray(ctx, values)
+	},
+)
+
+// items returns an array of [key, value] pairs.
+var bi_Hash_items = make_method(
+	make_argspec(VT_HASH),
+	func(ctx *Context, this Value, args []Value) Value {
+		items := []Value{}
+		for _, entry := range this.(*Hash).table.entries {
+			if entry.hasValue() {
+				items = append(items, newArray(ctx, []Value{*entry.key, *entry.value}))
+			}
+		}
+		return newArray(ctx, items)
+	},
+)
+
+var bi_Hash_iter = make_method(
+	make_argspec(VT_HASH),
+	func(ctx *Context, this Value, args []Value) Value {
+		return newIterator(ctx, newHashIterator(this.(*Hash)))
+	},
+)
+
 func bi_Hash_equal(ctx *Context, a, b Value) Value {
 	left := a.(*Hash)
 	right := b.(*Hash)
@@ -448,24 +1058,41 @@ func bi_Hash_equal(ctx *Context, a, b Value) Value {
 
 type Globals struct {
 	puts       *Builtin
+	print      *Builtin
+	eprint     *Builtin
+	input      *Builtin
+	require    *Builtin
 	set_slot   *Builtin
 	get_slot   *Builtin
 	slot_names *Builtin
 	get_proto  *Builtin
 	is_a       *Builtin
+	argv       *Object
 	Object     *Object
 	Function   *Object
 	Error      *Object
+	Iterator   *Object
 	Boolean    *Object
 	Number     *Object
 	String     *Object
 	Array      *Object
 	Hash       *Object
+	Math       *Object
+	rng        *rand.Rand
+	inspect    *Builtin // Object.inspect, see hasDefaultInspect.
+	// every global by name, including those set by Context.SetGlobal.
+	values map[string]Value
+	// the names of the builtin prototypes and namespaces, for inspect.
+	names map[*Object]string
 }
 
 func newGlobals() *Globals {
 	g := &Globals{}
 	g.puts = newBuiltin("puts", bi_puts)
+	g.print = newBuiltin("print", bi_print)
+	g.eprint = newBuiltin("eprint", bi_eprint)
+	g.input = newBuiltin("input", bi_input)
+	g.require = newBuiltin("require", bi_require)
 	g.set_slot = newBuiltin("set_slot", bi_set_slot)
 	g.get_slot = newBuiltin("set_slot", bi_get_slot)
 	g.slot_names = newBuiltin("slot_names", bi_slot_names)
@@ -473,93 +1100,180 @@ func newGlobals() *Globals {
 	g.is_a = newBuiltin("is_a", bi_is_a)
 
 	g.Object = newObject(nil)
-	g.Object.slots["clone"] = newBuiltin("clone", bi_Object_clone)
-	g.Object.slots["new"] = newBuiltin("clone", bi_Object_new)
-	g.Object.slots["inspect"] = newBuiltin("inspect", bi_Object_inspect)
-	g.Object.slots["=="] = newBuiltin("==", bi_Object_eq)
-	g.Object.slots["!="] = newBuiltin("!=", bi_Object_neq)
-
-	g.Function = newObject(g.Object)
-	g.Function.slots["bind"] = newBuiltin("bind", bi_Function_bind)
-	g.Function.slots["call"] = newBuiltin("call", bi_Function_call)
-	g.Function.slots["inspect"] = newBuiltin("inspect", bi_Function_inspect)
-
-	g.Error = newObject(g.Object)
-	g.Error.slots["throw"] = newBuiltin("throw", bi_Error_throw)
-
-	g.Boolean = newObject(g.Object)
-	g.Boolean.slots["init"] = newBuiltin("init", builtin_init(VT_BOOLEAN, FALSE))
-	g.Boolean.slots["inspect"] = newBuiltin("inspect", bi_Boolean_inspect)
-
-	g.Number = newObject(g.Object)
-	g.Number.slots["init"] = newBuiltin("init", builtin_init(VT_NUMBER, Number(0)))
-	g.Number.slots["=="] = binOp2Builtin("==", bi_Number_equal, VT_NUMBER, VT_NUMBER)
-	g.Number.slots["+"] = binOp2Builtin("+", bi_Number_plus, VT_NUMBER, VT_NUMBER)
-	g.Number.slots["-"] = binOp2Builtin("-", bi_Number_minus, VT_NUMBER, VT_NUMBER)
-	g.Number.slots["*"] = binOp2Builtin("*", bi_Number_times, VT_NUMBER, VT_NUMBER)
-	g.Number.slots["/"] = binOp2Builtin("/", bi_Number_divide, VT_NUMBER, VT_NUMBER)
-	g.Number.slots[">"] = binOp2Builtin(">", bi_Number_gt, VT_NUMBER, VT_NUMBER)
-	g.Number.slots[">="] = binOp2Builtin(">=", bi_Number_geq, VT_NUMBER, VT_NUMBER)
-	g.Number.slots["<"] = binOp2Builtin("<", bi_Number_lt, VT_NUMBER, VT_NUMBER)
-	g.Number.slots["<="] = binOp2Builtin("<=", bi_Number_leq, VT_NUMBER, VT_NUMBER)
-	g.Number.slots["inspect"] = newBuiltin("inspect", bi_Number_inspect)
-
-	g.String = newObject(g.Object)
-	g.String.slots["init"] = newBuiltin("init", builtin_init(VT_STRING, String("")))
-	g.String.slots["=="] = binOp2Builtin("==", bi_String_equal, VT_STRING, VT_STRING)
-	g.String.slots["+"] = binOp2Builtin("+", bi_String_plus, VT_STRING, VT_STRING)
-	g.String.slots[">"] = binOp2Builtin(">", bi_String_gt, VT_STRING, VT_STRING)
-	g.String.slots[">="] = binOp2Builtin(">=", bi_String_geq, VT_STRING, VT_STRING)
-	g.String.slots["<"] = binOp2Builtin("<", bi_String_lt, VT_STRING, VT_STRING)
-	g.String.slots["<="] = binOp2Builtin("<=", bi_String_leq, VT_STRING, VT_STRING)
-	g.String.slots["inspect"] = newBuiltin("inspect", bi_String_inspect)
-
-	g.Array = newObject(g.Object)
-	g.Array.slots["init"] = newBuiltin("init", bi_Array_init)
-	g.Array.slots["=="] = binOp2Builtin("==", bi_Array_equal, VT_ARRAY, VT_ARRAY)
-	g.Array.slots["+"] = binOp2Builtin("+", bi_Array_plus, VT_ARRAY, VT_ARRAY)
-	g.Array.slots["concat"] = newBuiltin("concat", bi_Array_concat)
-	g.Array.slots["size"] = newBuiltin("size", bi_Array_size)
-	g.Array.slots["get"] = newBuiltin("get", bi_Array_get)
-	g.Array.slots["set"] = newBuiltin("set", bi_Array_set)
-	g.Array.slots["push"] = newBuiltin("push", bi_Array_push)
-	g.Array.slots["pop"] = newBuiltin("pop", bi_Array_pop)
-	g.Array.slots["inspect_visit"] = newBuiltin("inspect_visit", bi_Array_inspect_visit)
-
-	g.Hash = newObject(g.Object)
-	g.Hash.slots["init"] = newBuiltin("init", bi_Hash_init)
-	g.Hash.slots["size"] = newBuiltin("size", bi_Hash_size)
-	g.Hash.slots["get"] = newBuiltin("get", bi_Hash_get)
-	g.Hash.slots["set"] = newBuiltin("set", bi_Hash_set)
-	g.Hash.slots["delete"] = newBuiltin("delete", bi_Hash_delete)
-	g.Hash.slots["=="] = binOp2Builtin("==", bi_Hash_equal, VT_HASH, VT_HASH)
-
+	g.Object.slots.set("clone", newBuiltin("clone", bi_Object_clone))
+	g.Object.slots.set("new", newBuiltin("clone", bi_Object_new))
+	g.inspect = newBuiltin("inspect", bi_Object_inspect)
+	g.Object.slots.set("inspect", g.inspect)
+	g.Object.slots.set("to_string", newBuiltin("to_string", bi_Object_to_string))
+	g.Object.slots.set("==", newBuiltin("==", bi_Object_eq))
+	g.Object.slots.set("!=", newBuiltin("!=", bi_Object_neq))
+	g.Object.slots.set("hash", newBuiltin("hash", bi_Object_hash))
+
+	g.Function = newProto(g.Object)
+	g.Function.slots.set("bind", newBuiltin("bind", bi_Function_bind))
+	g.Function.slots.set("call", newBuiltin("call", bi_Function_call))
+	g.Function.slots.set("inspect", newBuiltin("inspect", bi_Function_inspect))
+
+	g.Error = newProto(g.Object)
+	g.Error.slots.set("throw", newBuiltin("throw", bi_Error_throw))
+
+	g.Iterator = newProto(g.Object)
+	g.Iterator.slots.set("done", newBuiltin("done", bi_Iterator_done))
+	g.Iterator.slots.set("next", newBuiltin("next", bi_Iterator_next))
+	g.Iterator.slots.set("close", newBuiltin("close", bi_Iterator_close))
+	g.Iterator.slots.set("iter", newBuiltin("iter", bi_Iterator_iter))
+
+	g.Boolean = newProto(g.Object)
+	g.Boolean.slots.set("init", newBuiltin("init", builtin_init(VT_BOOLEAN, FALSE)))
+	g.Boolean.slots.set("inspect", newBuiltin("inspect", bi_Boolean_inspect))
+	g.Boolean.slots.set("hash", newBuiltin("hash", bi_Boolean_hash))
+
+	g.Number = newProto(g.Object)
+	g.Number.slots.set("init", newBuiltin("init", builtin_init(VT_NUMBER, Number(0))))
+	g.Number.slots.set("==", binOp2Builtin("==", bi_Number_equal, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("+", binOp2Builtin("+", bi_Number_plus, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("-", binOp2Builtin("-", bi_Number_minus, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("*", binOp2Builtin("*", bi_Number_times, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("/", binOp2Builtin("/", bi_Number_divide, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set(">", binOp2Builtin(">", bi_Number_gt, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set(">=", binOp2Builtin(">=", bi_Number_geq, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("<", binOp2Builtin("<", bi_Number_lt, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("<=", binOp2Builtin("<=", bi_Number_leq, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("%", binOp2Builtin("%", bi_Number_modulo, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("~/", binOp2Builtin("~/", bi_Number_floor_divide, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("**", binOp2Builtin("**", bi_Number_power, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("&", binOp2Builtin("&", bi_Number_and, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("|", binOp2Builtin("|", bi_Number_or, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("^", binOp2Builtin("^", bi_Number_xor, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("<<", binOp2Builtin("<<", bi_Number_lshift, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set(">>", binOp2Builtin(">>", bi_Number_rshift, VT_NUMBER, VT_NUMBER))
+	g.Number.slots.set("floor", newBuiltin("floor", bi_Number_floor))
+	g.Number.slots.set("ceil", newBuiltin("ceil", bi_Number_ceil))
+	g.Number.slots.set("round", newBuiltin("round", bi_Number_round))
+	g.Number.slots.set("abs", newBuiltin("abs", bi_Number_abs))
+	g.Number.slots.set("sqrt", newBuiltin("sqrt", bi_Number_sqrt))
+	g.Number.slots.set("is_nan", newBuiltin("is_nan", bi_Number_is_nan))
+	g.Number.slots.set("is_integer", newBuiltin("is_integer", bi_Number_is_integer))
+	g.Number.slots.set("to_string", newBuiltin("to_string", bi_Number_to_string))
+	g.Number.slots.set("inspect", newBuiltin("inspect", bi_Number_inspect))
+	g.Number.slots.set("hash", newBuiltin("hash", bi_Number_hash))
+
+	g.String = newProto(g.Object)
+	g.String.slots.set("init", newBuiltin("init", builtin_init(VT_STRING, String(""))))
+	g.String.slots.set("==", binOp2Builtin("==", bi_String_equal, VT_STRING, VT_STRING))
+	g.String.slots.set("+", binOp2Builtin("+", bi_String_plus, VT_STRING, VT_ANY))
+	g.String.slots.set(">", binOp2Builtin(">", bi_String_gt, VT_STRING, VT_STRING))
+	g.String.slots.set(">=", binOp2Builtin(">=", bi_String_geq, VT_STRING, VT_STRING))
+	g.String.slots.set("<", binOp2Builtin("<", bi_String_lt, VT_STRING, VT_STRING))
+	g.String.slots.set("<=", binOp2Builtin("<=", bi_String_leq, VT_STRING, VT_STRING))
+	g.String.slots.set("size", newBuiltin("size", bi_String_size))
+	g.String.slots.set("byte_size", newBuiltin("byte_size", bi_String_byte_size))
+	g.String.slots.set("get", newBuiltin("get", bi_String_get))
+	g.String.slots.set("byte_at", newBuiltin("byte_at", bi_String_byte_at))
+	g.String.slots.set("slice", newBuiltin("slice", bi_String_slice))
+	g.String.slots.set("find", newBuiltin("find", bi_String_find))
+	g.String.slots.set("split", newBuiltin("split", bi_String_split))
+	g.String.slots.set("join", newBuiltin("join", bi_String_join))
+	g.String.slots.set("replace", newBuiltin("replace", bi_String_replace))
+	g.String.slots.set("upper", newBuiltin("upper", bi_String_upper))
+	g.String.slots.set("lower", newBuiltin("lower", bi_String_lower))
+	g.String.slots.set("trim", newBuiltin("trim", bi_String_trim))
+	g.String.slots.set("starts_with", newBuiltin("starts_with", bi_String_starts_with))
+	g.String.slots.set("ends_with", newBuiltin("ends_with", bi_String_ends_with))
+	g.String.slots.set("repeat", newBuiltin("repeat", bi_String_repeat))
+	g.String.slots.set("format", newBuiltin("format", bi_String_format))
+	g.String.slots.set("to_number", newBuiltin("to_number", bi_String_to_number))
+	g.String.slots.set("iter", newBuiltin("iter", bi_String_iter))
+	g.String.slots.set("hash", newBuiltin("hash", bi_String_hash))
+	g.String.slots.set("inspect", newBuiltin("inspect", bi_String_inspect))
+	g.String.slots.set("to_string", newBuiltin("to_string", bi_String_to_string))
+
+	g.Array = newProto(g.Object)
+	g.Array.slots.set("init", newBuiltin("init", bi_Array_init))
+	g.Array.slots.set("==", binOp2Builtin("==", bi_Array_equal, VT_ARRAY, VT_ARRAY))
+	g.Array.slots.set("+", binOp2Builtin("+", bi_Array_plus, VT_ARRAY, VT_ARRAY))
+	g.Array.slots.set("concat", newBuiltin("concat", bi_Array_concat))
+	g.Array.slots.set("size", newBuiltin("size", bi_Array_size))
+	g.Array.slots.set("get", newBuiltin("get", bi_Array_get))
+	g.Array.slots.set("set", newBuiltin("set", bi_Array_set))
+	g.Array.slots.set("push", newBuiltin("push", bi_Array_push))
+	g.Array.slots.set("pop", newBuiltin("pop", bi_Array_pop))
+	g.Array.slots.set("iter", newBuiltin("iter", bi_Array_iter))
+	g.Array.slots.set("hash", newBuiltin("hash", bi_Array_hash))
+	g.Array.slots.set("inspect_visit", newBuiltin("inspect_visit", bi_Array_inspect_visit))
+
+	g.Hash = newProto(g.Object)
+	g.Hash.slots.set("init", newBuiltin("init", bi_Hash_init))
+	g.Hash.slots.set("size", newBuiltin("size", bi_Hash_size))
+	g.Hash.slots.set("get", newBuiltin("get", bi_Hash_get))
+	g.Hash.slots.set("set", newBuiltin("set", bi_Hash_set))
+	g.Hash.slots.set("delete", newBuiltin("delete", bi_Hash_delete))
+	g.Hash.slots.set("has", newBuiltin("has", bi_Hash_has))
+	g.Hash.slots.set("clear", newBuiltin("clear", bi_Hash_clear))
+	g.Hash.slots.set("keys", newBuiltin("keys", bi_Hash_keys))
+	g.Hash.slots.set("values", newBuiltin("values", bi_Hash_values))
+	g.Hash.slots.set("items", newBuiltin("items", bi_Hash_items))
+	g.Hash.slots.set("iter", newBuiltin("iter", bi_Hash_iter))
+	g.Hash.slots.set("hash", newBuiltin("hash", bi_Hash_hash))
+	g.Hash.slots.set("inspect_visit", newBuiltin("inspect_visit", bi_Hash_inspect_visit))
+	g.Hash.slots.set("==", binOp2Builtin("==", bi_Hash_equal, VT_HASH, VT_HASH))
+
+	g.argv = newObject(g.Array)
+	g.argv.data = &Array{[]Value{}}
+
+	g.rng = rand.New(rand.NewSource(int64(getNewHashTableSeed())))
+	g.Math = newMath(g)
+
+	g.values = map[string]Value{
+		"puts":       g.puts,
+		"print":      g.print,
+		"eprint":     g.eprint,
+		"input":      g.input,
+		"require":    g.require,
+		"set_slot":   g.set_slot,
+		"get_slot":   g.get_slot,
+		"slot_names": g.slot_names,
+		"get_proto":  g.get_proto,
+		"is_a":       g.is_a,
+		"ARGV":       g.argv,
+		"Object":     g.Object,
+		"Function":   g.Function,
+		"Error":      g.Error,
+		"Iterator":   g.Iterator,
+		"Boolean":    g.Boolean,
+		"Number":     g.Number,
+		"String":     g.String,
+		"Array":      g.Array,
+		"Hash":       g.Hash,
+		"Math":       g.Math,
+	}
+	g.names = map[*Object]string{
+		g.Object:   "Object",
+		g.Function: "Function",
+		g.Error:    "Error",
+		g.Iterator: "Iterator",
+		g.Boolean:  "Boolean",
+		g.Number:   "Number",
+		g.String:   "String",
+		g.Array:    "Array",
+		g.Hash:     "Hash",
+		g.Math:     "Math",
+	}
 	return g
 }
 
 func (g *Globals) addToEnv(env *environment) {
-	env.set("puts", g.puts)
-	env.set("set_slot", g.set_slot)
-	env.set("get_slot", g.get_slot)
-	env.set("slot_names", g.slot_names)
-	env.set("get_proto", g.get_proto)
-	env.set("is_a", g.is_a)
-	env.set("Object", g.Object)
-	env.set("Function", g.Function)
-	env.set("Error", g.Error)
-	env.set("Boolean", g.Boolean)
-	env.set("Number", g.Number)
-	env.set("String", g.String)
-	env.set("Array", g.Array)
-	env.set("Hash", g.Hash)
+	for name, value := range g.values {
+		env.set(name, value)
+	}
 }
 
 func (g *Globals) addToResolver(r *resolver.Resolver) {
-	r.AddGlobals([]string{
-		"puts",
-		"set_slot", "get_slot", "slot_names", "get_proto", "is_a",
-		"Object", "Function", "Error", "Number", "String", "Array", "Hash",
-	})
+	names := []string{"exports"}
+	for name := range g.values {
+		names = append(names, name)
+	}
+	r.AddGlobals(names)
 }
 
 // ========
@@ -605,7 +1319,7 @@ func expectArgType(ctx *Context, argName string, value Value, expectedType Value
 type binOpFunc func(*Context, Value, Value) Value
 
 func binOp2Builtin(name string, f binOpFunc, ltype, rtype ValueType) *Builtin {
-	return newBuiltin(name, func(ctx *Context, this Value, args []Value) Value {
+	b := newBuiltin(name, func(ctx *Context, this Value, args []Value) Value {
 		if err := expectNArgs(ctx, args, 1); err != nil {
 			return err
 		}
@@ -613,19 +1327,23 @@ func binOp2Builtin(name string, f binOpFunc, ltype, rtype ValueType) *Builtin {
 		if left == nil {
 			return ctx.forward(this, name, args)
 		}
-		right := ctx.getSpecial(args[0], rtype)
+		right := args[0]
+		if rtype != VT_ANY {
+			right = ctx.getSpecial(right, rtype)
+		}
 		if right == nil {
 			return ctx.forward(this, name, args)
 		}
 		return f(ctx, left, right)
 	})
+	b.op, b.ltype, b.rtype = f, ltype, rtype
+	return b
 }
 
 // builtin_init generates an init method for immutable builtins, i.e. String,
 // Number, Boolean.
-func builtin_init(typ ValueType, zero Value) builtinFunc {
+func builtin_init(typ ValueType, zero Value) BuiltinFunc {
 	return func(ctx *Context, this Value, args []Value) Value {
-		fmt.Println(this)
 		if this.Type() == VT_OBJECT {
 			obj := this.(*Object)
 			if obj.data != nil {
@@ -647,36 +1365,77 @@ func builtin_init(typ ValueType, zero Value) builtinFunc {
 	}
 }
 
-type argPair struct {
-	name string
-	typ ValueType
+// normaliseIndex turns a (possibly negative) index into a sequence
+// of size n into a Go index, returning false if it is out of bounds.
+func normaliseIndex(idx Number, n int) (int, bool) {
+	i := int(idx)
+	if i < 0 {
+		i += n
+	}
+	return i, 0 <= i && i < n
+}
+
+// toInt64 returns the integer value of n, if n is integral.
+func toInt64(n Number) (int64, bool) {
+	f := float64(n)
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// clampIndex is like normaliseIndex, but clamps the index to [0, n].
+func clampIndex(idx Number, n int) int {
+	i := int(idx)
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// Arg names an argument of a builtin, and the type it must have (see
+// ArgSpec).
+type Arg struct {
+	Name string
+	Type ValueType
 }
 
-type argSpec struct {
-	this ValueType
-	args []argPair
+// ArgSpec describes the receiver and arguments expected by a builtin.
+// VT_ANY accepts anything, VT_CALL accepts callables, and other types
+// accept any value having that type on its prototype chain. The builtin
+// then receives the underlying values, e.g. a String for a VT_STRING
+// argument.
+type ArgSpec struct {
+	This ValueType
+	Args []Arg
 }
 
-func make_argpair(name string, typ ValueType) argPair { return argPair{name, typ} }
-func make_argspec(this ValueType, args ...argPair) argSpec {
-	return argSpec{this, args}
+func make_argpair(name string, typ ValueType) Arg { return Arg{name, typ} }
+func make_argspec(this ValueType, args ...Arg) ArgSpec {
+	return ArgSpec{this, args}
 }
 
-func make_method(spec argSpec, fn builtinFunc) builtinFunc {
+func make_method(spec ArgSpec, fn BuiltinFunc) BuiltinFunc {
 	return func(ctx *Context, this Value, args []Value) Value {
 		// check the argspec.
-		newThis, err := expectArgType(ctx, "this", this, spec.this)
+		newThis, err := expectArgType(ctx, "this", this, spec.This)
 		if err != nil {
 			ctx.addErrorStackBuiltin(err)
 			return err
 		}
-		if err := expectNArgs(ctx, args, len(spec.args)); err != nil {
+		if err := expectNArgs(ctx, args, len(spec.Args)); err != nil {
 			ctx.addErrorStackBuiltin(err)
 			return err
 		}
 		newArgs := make([]Value, len(args))
 		for i, arg := range args {
-			value, err := expectArgType(ctx, spec.args[i].name, arg, spec.args[i].typ)
+			value, err := expectArgType(ctx, spec.Args[i].Name, arg, spec.Args[i].Type)
 			if err != nil {
 				ctx.addErrorStackBuiltin(err)
 				return err
@@ -0,0 +1,470 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// ==============
+// Go Conversions
+// ==============
+//
+// ToValue and FromValue convert between Go and toe values:
+//
+//      Go                          toe
+//      nil, nil pointers and funcs nil
+//      bool                        Boolean
+//      ints, uints, floats         Number
+//      string                      String
+//      slices, arrays              Array
+//      maps                        Hash
+//      structs                     Object, with a slot per exported field
+//      funcs                       Builtin, see Bind
+//
+// Go values which already are toe values (e.g. *Object) are passed as-is.
+// Struct fields and methods are named in snake_case, so a field UserID
+// becomes the slot user_id; a `toe:"name"' tag overrides the name, and
+// `toe:"-"' skips the field.
+
+var (
+	valueType   = reflect.TypeOf((*Value)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*Context)(nil))
+)
+
+// ToValue converts x to a toe value. x must not contain cycles.
+func (ctx *Context) ToValue(x interface{}) (Value, error) {
+	if x == nil {
+		return NIL, nil
+	}
+	return ctx.toValue(reflect.ValueOf(x))
+}
+
+func (ctx *Context) toValue(rv reflect.Value) (Value, error) {
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Func:
+		if rv.IsNil() {
+			return NIL, nil
+		}
+	}
+	if rv.Type().Implements(valueType) {
+		return rv.Interface().(Value), nil
+	}
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return ctx.toValue(rv.Elem())
+	case reflect.Bool:
+		return Boolean(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Number(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Number(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return Number(rv.Float()), nil
+	case reflect.String:
+		return String(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		values := make([]Value, rv.Len())
+		for i := range values {
+			v, err := ctx.toValue(rv.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %s", i, err)
+			}
+			values[i] = v
+		}
+		return newArray(ctx, values), nil
+	case reflect.Map:
+		obj := newHash(ctx)
+		table := obj.data.(*Hash).table
+		iter := rv.MapRange()
+		for iter.Next() {
+			k, err := ctx.toValue(iter.Key())
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %s", iter.Key(), err)
+			}
+			v, err := ctx.toValue(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %s", iter.Key(), err)
+			}
+			if err := table.insert(k, v); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case reflect.Struct:
+		obj := newObject(ctx.globals.Object)
+		for _, f := range structFields(rv.Type()) {
+			v, err := ctx.toValue(rv.Field(f.index))
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", f.name, err)
+			}
+			obj.slots.set(f.name, v)
+		}
+		return obj, nil
+	case reflect.Func:
+		return bindFunc(funcName(rv), rv)
+	}
+	return nil, fmt.Errorf("cannot convert %s to a toe value", rv.Type())
+}
+
+// FromValue converts v, storing the result in the value pointed to by
+// target. Conversions to interface{} give nil, bool, float64, string,
+// []interface{} and map[string]interface{}; other values (e.g. functions)
+// are stored as-is.
+func (ctx *Context) FromValue(v Value, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("target must be a non-nil pointer")
+	}
+	return ctx.fromValue(v, rv.Elem())
+}
+
+func (ctx *Context) fromValue(v Value, rv reflect.Value) error {
+	t := rv.Type()
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		x, err := ctx.toGo(v)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			rv.Set(reflect.Zero(t))
+		} else {
+			rv.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+	if reflect.TypeOf(v).AssignableTo(t) {
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if v == NIL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		ptr := reflect.New(t.Elem())
+		if err := ctx.fromValue(v, ptr.Elem()); err != nil {
+			return err
+		}
+		rv.Set(ptr)
+		return nil
+	case reflect.Bool:
+		if b := ctx.getSpecial(v, VT_BOOLEAN); b != nil {
+			rv.SetBool(bool(b.(Boolean)))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := ctx.getSpecial(v, VT_NUMBER); n != nil {
+			i, ok := toInt64(n.(Number))
+			if !ok || rv.OverflowInt(i) {
+				return fmt.Errorf("%s out of range for %s", formatNumber(n.(Number)), t)
+			}
+			rv.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := ctx.getSpecial(v, VT_NUMBER); n != nil {
+			i, ok := toInt64(n.(Number))
+			if !ok || i < 0 || rv.OverflowUint(uint64(i)) {
+				return fmt.Errorf("%s out of range for %s", formatNumber(n.(Number)), t)
+			}
+			rv.SetUint(uint64(i))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if n := ctx.getSpecial(v, VT_NUMBER); n != nil {
+			rv.SetFloat(float64(n.(Number)))
+			return nil
+		}
+	case reflect.String:
+		if s := ctx.getSpecial(v, VT_STRING); s != nil {
+			rv.SetString(string(s.(String)))
+			return nil
+		}
+	case reflect.Slice, reflect.Array:
+		if arr := ctx.getSpecial(v, VT_ARRAY); arr != nil {
+			values := arr.(*Array).values
+			if t.Kind() == reflect.Slice {
+				rv.Set(reflect.MakeSlice(t, len(values), len(values)))
+			} else if len(values) != t.Len() {
+				return fmt.Errorf("expected an array of size %d, got=%d", t.Len(), len(values))
+			}
+			for i, elem := range values {
+				if err := ctx.fromValue(elem, rv.Index(i)); err != nil {
+					return fmt.Errorf("index %d: %s", i, err)
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if hash := ctx.getSpecial(v, VT_HASH); hash != nil {
+			m := reflect.MakeMap(t)
+			for _, entry := range hash.(*Hash).table.entries {
+				if !entry.hasValue() {
+					continue
+				}
+				key := reflect.New(t.Key()).Elem()
+				if err := ctx.fromValue(*entry.key, key); err != nil {
+					return fmt.Errorf("key: %s", err)
+				}
+				value := reflect.New(t.Elem()).Elem()
+				if err := ctx.fromValue(*entry.value, value); err != nil {
+					return fmt.Errorf("key %v: %s", key, err)
+				}
+				m.SetMapIndex(key, value)
+			}
+			rv.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		// fields are taken from hash keys, or from slots otherwise.
+		hash := ctx.getSpecial(v, VT_HASH)
+		if _, ok := v.(*Object); !ok && hash == nil {
+			break
+		}
+		for _, f := range structFields(t) {
+			var field Value
+			if hash != nil {
+				value, found, err := hash.(*Hash).table.get(String(f.name))
+				if err != nil {
+					return err
+				}
+				if found {
+					field = value
+				}
+			} else {
+				field = ctx.maybeGetSlot(v, f.name, nil)
+			}
+			if field == nil {
+				continue
+			}
+			if err := ctx.fromValue(field, rv.Field(f.index)); err != nil {
+				return fmt.Errorf("field %s: %s", f.name, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot convert %s to %s", v.Type(), t)
+}
+
+// toGo returns the natural Go value for v, see FromValue.
+func (ctx *Context) toGo(v Value) (interface{}, error) {
+	switch v := v.(type) {
+	case Nil:
+		return nil, nil
+	case Boolean:
+		return bool(v), nil
+	case Number:
+		return float64(v), nil
+	case String:
+		return string(v), nil
+	case *Object:
+		switch data := v.data.(type) {
+		case Boolean, Number, String:
+			return ctx.toGo(data)
+		case *Array:
+			values := make([]interface{}, len(data.values))
+			for i, elem := range data.values {
+				x, err := ctx.toGo(elem)
+				if err != nil {
+					return nil, fmt.Errorf("index %d: %s", i, err)
+				}
+				values[i] = x
+			}
+			return values, nil
+		case *Hash:
+			m := map[string]interface{}{}
+			for _, entry := range data.table.entries {
+				if !entry.hasValue() {
+					continue
+				}
+				key, ok := (*entry.key).(String)
+				if !ok {
+					return nil, fmt.Errorf("cannot convert hash with %s keys", (*entry.key).Type())
+				}
+				x, err := ctx.toGo(*entry.value)
+				if err != nil {
+					return nil, fmt.Errorf("key %q: %s", string(key), err)
+				}
+				m[string(key)] = x
+			}
+			return m, nil
+		}
+	}
+	return v, nil
+}
+
+type structField struct {
+	name  string
+	index int
+}
+
+// structFields returns the fields of t which are converted to slots.
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := snakeCase(f.Name)
+		if tag, ok := f.Tag.Lookup("toe"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		fields = append(fields, structField{name, i})
+	}
+	return fields
+}
+
+// snakeCase converts a Go name to snake_case, e.g. HTTPServer becomes
+// http_server.
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && runes[i-1] != '_' && (!unicode.IsUpper(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ========
+// Bindings
+// ========
+//
+// Bind wraps a Go function as a builtin. Arguments are converted with
+// FromValue to the function's parameter types, and the builtin throws an
+// error if there are too few or too many of them, or if one of them
+// cannot be converted. If the first parameter is a *Context, then it is
+// passed the calling context.
+//
+// The function may return nothing, a value, an error, or a value and an
+// error. Values are converted with ToValue, and a non-nil error is thrown:
+// an *Error as-is, otherwise with the error's message as the reason. If
+// the function panics, then the panic is recovered and thrown as well (see
+// Builtin.Call).
+
+// Bind returns a builtin which calls the Go function fn.
+func Bind(name string, fn interface{}) (*Builtin, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("cannot bind %T: not a function", fn)
+	}
+	return bindFunc(name, rv)
+}
+
+// BindMethods returns an object with a builtin slot for each exported
+// method of recv, e.g. a method AddItem becomes the slot add_item.
+func (ctx *Context) BindMethods(recv interface{}) (*Object, error) {
+	rv := reflect.ValueOf(recv)
+	if !rv.IsValid() {
+		return nil, errors.New("cannot bind methods of nil")
+	}
+	obj := newObject(ctx.globals.Object)
+	for i := 0; i < rv.NumMethod(); i++ {
+		name := snakeCase(rv.Type().Method(i).Name)
+		b, err := bindFunc(name, rv.Method(i))
+		if err != nil {
+			return nil, err
+		}
+		obj.slots.set(name, b)
+	}
+	return obj, nil
+}
+
+func bindFunc(name string, fn reflect.Value) (*Builtin, error) {
+	t := fn.Type()
+	switch {
+	case t.NumOut() > 2:
+		return nil, fmt.Errorf("cannot bind %s: too many results", name)
+	case t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, fmt.Errorf("cannot bind %s: second result must be an error", name)
+	}
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		first = 1
+	}
+	return newBuiltin(name, func(ctx *Context, this Value, args []Value) Value {
+		if err := checkNArgs(ctx, t, first, len(args)); err != nil {
+			return ctx.addErrorStackBuiltin(err)
+		}
+		in := make([]reflect.Value, 0, first+len(args))
+		if first == 1 {
+			in = append(in, reflect.ValueOf(ctx))
+		}
+		for i, arg := range args {
+			var typ reflect.Type
+			if t.IsVariadic() && first+i >= t.NumIn()-1 {
+				typ = t.In(t.NumIn() - 1).Elem()
+			} else {
+				typ = t.In(first + i)
+			}
+			param := reflect.New(typ).Elem()
+			if err := ctx.fromValue(arg, param); err != nil {
+				e := newError(ctx, String(fmt.Sprintf("argument %d: %s", i+1, err)))
+				return ctx.addErrorStackBuiltin(e)
+			}
+			in = append(in, param)
+		}
+		return ctx.fromResults(fn.Call(in))
+	}), nil
+}
+
+// checkNArgs checks the number of arguments passed to a bound function.
+func checkNArgs(ctx *Context, t reflect.Type, first int, n int) *Error {
+	params := t.NumIn() - first
+	if t.IsVariadic() {
+		if n < params-1 {
+			return newError(ctx, String(fmt.Sprintf("expected at least %d argument(s), got=%d", params-1, n)))
+		}
+	} else if n != params {
+		return newError(ctx, String(fmt.Sprintf("expected %d argument(s), got=%d", params, n)))
+	}
+	return nil
+}
+
+// fromResults converts the results of a bound function.
+func (ctx *Context) fromResults(out []reflect.Value) Value {
+	if n := len(out); n > 0 && out[n-1].Type() == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			if e, ok := err.(*Error); ok {
+				return e
+			}
+			return ctx.addErrorStackBuiltin(newError(ctx, String(err.Error())))
+		}
+		out = out[:n-1]
+	}
+	if len(out) == 0 {
+		return NIL
+	}
+	v, err := ctx.toValue(out[0])
+	if err != nil {
+		return ctx.addErrorStackBuiltin(newError(ctx, String(err.Error())))
+	}
+	return v
+}
+
+func funcName(fn reflect.Value) string {
+	if f := runtime.FuncForPC(fn.Pointer()); f != nil {
+		name := f.Name()
+		return name[strings.LastIndex(name, ".")+1:]
+	}
+	return "<func>"
+}
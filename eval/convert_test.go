@@ -0,0 +1,288 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testItem struct {
+	Name     string
+	Price    float64
+	Tags     []string
+	Internal string `toe:"-"`
+	SKU      string `toe:"code"`
+	Count    int
+	hidden   int
+}
+
+type testCart struct {
+	items []testItem
+}
+
+func (c *testCart) AddItem(item testItem) int {
+	c.items = append(c.items, item)
+	return len(c.items)
+}
+
+func (c *testCart) Total() float64 {
+	total := 0.0
+	for _, item := range c.items {
+		total += item.Price * float64(item.Count)
+	}
+	return total
+}
+
+func (c *testCart) Remove(i int) (testItem, error) {
+	if i < 0 || i >= len(c.items) {
+		return testItem{}, fmt.Errorf("no item %d", i)
+	}
+	item := c.items[i]
+	c.items = append(c.items[:i], c.items[i+1:]...)
+	return item, nil
+}
+
+func TestToValue(t *testing.T) {
+	ctx := NewContext()
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "nil"},
+		{(*int)(nil), "nil"},
+		{(func())(nil), "nil"},
+		{true, "true"},
+		{uint8(7), "7"},
+		{-1.5, "-1.5"},
+		{"hi", `"hi"`},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]interface{}{"a", nil}, `["a", nil]`},
+		{map[string]int{"a": 1}, `{"a": 1}`},
+		{&testItem{Name: "x", Tags: []string{}, Internal: "y", SKU: "z", hidden: 1}, ""},
+		{Number(1), "1"},
+	}
+	for i, test := range tests {
+		v, err := ctx.ToValue(test.input)
+		if err != nil {
+			t.Errorf("tests[%d]: unexpected error: %s", i, err)
+			continue
+		}
+		if test.expected == "" {
+			continue
+		}
+		if s, _ := ctx.Inspect(v); s != test.expected {
+			t.Errorf("tests[%d]: expected %s, got=%s", i, test.expected, s)
+		}
+	}
+
+	v, _ := ctx.ToValue(testItem{Name: "x", SKU: "z"})
+	names := v.(*Object).slots.names()
+	if expected := []string{"name", "price", "tags", "code", "count"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected slots %v, got=%v", expected, names)
+	}
+
+	if _, err := ctx.ToValue(make(chan int)); err == nil {
+		t.Errorf("expected an error converting a channel")
+	}
+	if _, err := ctx.ToValue(map[string]interface{}{"a": []interface{}{1, make(chan int)}}); err == nil {
+		t.Errorf("expected an error converting a nested channel")
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	ctx := NewContext()
+	exports, err := ctx.Run("<test>", `
+let Item = Object.clone();
+Item.count = 1;
+exports.item = Item.new();
+exports.item.name = "pen";
+exports.item.price = 2.5;
+exports.item.tags = ["a", "b"];
+exports.hash = {"name": "cup", "count": 3, "code": "C1"};
+exports.scores = {"a": 1, "b": 2};
+exports.any = [1, "x", nil, {"k": true}, Object];
+exports.boxed = String.new("s");
+exports.fraction = 0.5;
+exports.negative = -1;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(name string) Value { return ctx.maybeGetSlot(exports, name, nil) }
+
+	var item testItem
+	if err := ctx.FromValue(get("item"), &item); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (testItem{Name: "pen", Price: 2.5, Tags: []string{"a", "b"}, Count: 1}); !reflect.DeepEqual(item, expected) {
+		t.Errorf("expected %+v, got=%+v", expected, item)
+	}
+	var ptr *testItem
+	if err := ctx.FromValue(get("hash"), &ptr); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (testItem{Name: "cup", SKU: "C1", Count: 3}); ptr == nil || !reflect.DeepEqual(*ptr, expected) {
+		t.Errorf("expected %+v, got=%+v", expected, ptr)
+	}
+	var scores map[string]uint
+	if err := ctx.FromValue(get("scores"), &scores); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]uint{"a": 1, "b": 2}; !reflect.DeepEqual(scores, expected) {
+		t.Errorf("expected %v, got=%v", expected, scores)
+	}
+	var any interface{}
+	if err := ctx.FromValue(get("any"), &any); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{1.0, "x", nil, map[string]interface{}{"k": true}, ctx.globals.Object}
+	if !reflect.DeepEqual(any, expected) {
+		t.Errorf("expected %#v, got=%#v", expected, any)
+	}
+	var s string
+	if err := ctx.FromValue(get("boxed"), &s); err != nil || s != "s" {
+		t.Errorf("expected boxed string, got=%q, %v", s, err)
+	}
+	var v Value
+	if err := ctx.FromValue(get("item"), &v); err != nil || v != get("item") {
+		t.Errorf("expected Values to be stored as-is, got=%#v, %v", v, err)
+	}
+
+	errorTests := []struct {
+		value    Value
+		target   interface{}
+		expected string
+	}{
+		{get("fraction"), new(int), "0.5 out of range for int"},
+		{get("negative"), new(uint), "-1 out of range for uint"},
+		{Number(300), new(int8), "300 out of range for int8"},
+		{String("x"), new(bool), "cannot convert VT_STRING to bool"},
+		{get("any"), new([]string), "index 0: cannot convert VT_NUMBER to string"},
+		{get("any"), new([2]interface{}), "expected an array of size 2, got=5"},
+		{get("hash"), new(map[string]string), "key count: cannot convert VT_NUMBER to string"},
+		{get("item"), new(struct{ Name int }), "field name: cannot convert VT_STRING to int"},
+		{Number(1), new(testItem), "cannot convert VT_NUMBER to eval.testItem"},
+		{String("x"), new(testItem), "cannot convert VT_STRING to eval.testItem"},
+		{NIL, new(testItem), "cannot convert VT_NIL to eval.testItem"},
+		{get("any"), testItem{}, "target must be a non-nil pointer"},
+	}
+	for i, test := range errorTests {
+		err := ctx.FromValue(test.value, test.target)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("errorTests[%d]: expected error %q, got=%v", i, test.expected, err)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	ctx := NewContext()
+	bind := func(name string, fn interface{}) {
+		b, err := Bind(name, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx.SetGlobal(name, b)
+	}
+	bind("add", func(a, b int) int { return a + b })
+	bind("join", func(sep string, parts ...string) string { return strings.Join(parts, sep) })
+	bind("fail", func() error { return errors.New("failed") })
+	bind("rethrow", func(ctx *Context, fn Value) (Value, error) { return ctx.Call(fn) })
+	bind("nothing", func() {})
+	bind("items", func(n int) []testItem { return make([]testItem, n) })
+	bind("explode", func() { panic("boom") })
+	bind("index", func(xs []int, i int) int { return xs[i] })
+	cart, err := ctx.BindMethods(&testCart{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetGlobal("cart", cart)
+
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{`add(1, 2)`, `3`},
+		{`join("-")`, `""`},
+		{`join("-", "a", "b", "c")`, `"a-b-c"`},
+		{`nothing()`, `nil`},
+		{`items(2).size()`, `2`},
+		{`items(1)[0].name`, `""`},
+		{`cart.add_item({"name": "pen", "price": 2, "count": 3})`, `1`},
+		{`cart.add_item({"name": "cup", "price": 5, "count": 1})`, `2`},
+		{`cart.total()`, `11`},
+		{`cart.remove(0).name`, `"pen"`},
+		{`cart.total()`, `5`},
+	}
+	for _, test := range tests {
+		exports, err := ctx.Run("<test>", "exports.x = "+test.source+";")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.source, err)
+			continue
+		}
+		s, _ := ctx.Inspect(ctx.maybeGetSlot(exports, "x", nil))
+		if s != test.expected {
+			t.Errorf("%s: expected %s, got=%s", test.source, test.expected, s)
+		}
+	}
+
+	errorTests := []struct {
+		source string
+		reason string
+	}{
+		{`add(1);`, "expected 2 argument(s), got=1"},
+		{`add(1, "2");`, "argument 2: cannot convert VT_STRING to int"},
+		{`join();`, "expected at least 1 argument(s), got=0"},
+		{`join(",", "a", 1);`, "argument 3: cannot convert VT_NUMBER to string"},
+		{`fail();`, "failed"},
+		{`rethrow(fn() { nil.x; });`, `object has no slot "x"`},
+		{`cart.remove(5);`, "no item 5"},
+		{`explode();`, "explode panicked: boom"},
+		{`index([1], 2);`, "index panicked: runtime error: index out of range [2] with length 1"},
+		{`rethrow(explode);`, "explode panicked: boom"},
+	}
+	for _, test := range errorTests {
+		_, err := ctx.Run("<test>", test.source)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected an *Error, got=%#v", test.source, err)
+			continue
+		}
+		if e.Reason() != String(test.reason) {
+			t.Errorf("%s: expected reason %q, got=%#v", test.source, test.reason, e.Reason())
+		}
+		if len(ctx.stack) != 0 || ctx.env != nil {
+			t.Errorf("%s: expected the stack and env to be unwound", test.source)
+		}
+	}
+
+	badTests := []interface{}{
+		nil,
+		1,
+		func() (int, int) { return 0, 0 },
+		func() (int, error, error) { return 0, nil, nil },
+	}
+	for i, fn := range badTests {
+		if _, err := Bind("bad", fn); err == nil {
+			t.Errorf("badTests[%d]: expected an error", i)
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"Name":       "name",
+		"AddItem":    "add_item",
+		"UserID":     "user_id",
+		"HTTPServer": "http_server",
+		"ToUTF8":     "to_utf8",
+		"Has_Under":  "has_under",
+		"X":          "x",
+	}
+	for input, expected := range tests {
+		if got := snakeCase(input); got != expected {
+			t.Errorf("snakeCase(%q): expected %q, got=%q", input, expected, got)
+		}
+	}
+}
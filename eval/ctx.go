@@ -1,9 +1,17 @@
 package eval
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"toe/compiler"
+	"toe/diag"
 	"toe/lexer"
 	"toe/parser"
+	"toe/resolver"
 )
 
 type Context struct {
@@ -19,18 +27,114 @@ type Context struct {
 	ht_seed uint64
 	// object model
 	globals *Globals
+	// bumped whenever a slot is added to a prototype, see inlineCache.
+	protoEpoch uint64
+	// modules loaded by require(), keyed by canonical path, and the
+	// modules currently being loaded (to detect import cycles).
+	modules map[string]*moduleEntry
+	loading []string
+	// whether modules are compiled to bytecode, see UseCompiler.
+	compile bool
+	// the maximum size of the call stack, see SetMaxDepth.
+	maxDepth int
+	// cancellation and step limits, see SetContext and SetStepLimit.
+	goctx    context.Context
+	steps    uint64
+	maxSteps uint64
+	// streams used by puts, print, eprint and input, see SetStdout.
+	stdout io.Writer
+	stderr io.Writer
+	stdin  *bufio.Reader
+	// the source of every module parsed, see FormatError.
+	sources diag.Sources
 }
 
+// DefaultMaxDepth is the default limit on the depth of the call stack.
+const DefaultMaxDepth = 10000
+
 func NewContext() *Context {
 	return &Context{
-		stack:   make([]callStackEntry, 0, 8),
-		ht_seed: getNewHashTableSeed(),
-		globals: newGlobals(),
+		stack:    make([]callStackEntry, 0, 8),
+		ht_seed:  getNewHashTableSeed(),
+		globals:  newGlobals(),
+		modules:  map[string]*moduleEntry{},
+		compile:  true,
+		maxDepth: DefaultMaxDepth,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		sources:  diag.Sources{},
+	}
+}
+
+// NewResolver returns a resolver for the module which knows about
+// the globals defined by this context.
+func (ctx *Context) NewResolver(module *parser.Module) *resolver.Resolver {
+	r := resolver.New(module)
+	ctx.globals.addToResolver(r)
+	return r
+}
+
+// SetArgs sets the contents of the ARGV array.
+func (ctx *Context) SetArgs(args []string) {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		values[i] = String(arg)
 	}
+	ctx.globals.argv.data = &Array{values}
+}
+
+// UseCompiler sets whether modules evaluated by ctx (including those
+// loaded by require()) are compiled to bytecode and run on the VM,
+// instead of being interpreted. The compiler is used by default.
+func (ctx *Context) UseCompiler(enabled bool) {
+	ctx.compile = enabled
+}
+
+// SetMaxDepth sets the maximum depth of the call stack (counting modules,
+// functions and builtins). Calls which go deeper fail with a "maximum
+// recursion depth exceeded" error. Since each call also uses the Go
+// stack, very large limits may crash the process instead.
+func (ctx *Context) SetMaxDepth(depth int) {
+	ctx.maxDepth = depth
+}
+
+// ErrStepLimit is the cause of errors from exceeding the step limit.
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// SetContext makes evaluation abort once c is done (e.g. cancelled or past
+// its deadline). The error returned for an aborted evaluation cannot be
+// caught by try statements, and does not run finally blocks; see
+// Error.Aborted.
+func (ctx *Context) SetContext(c context.Context) {
+	ctx.goctx = c
+}
+
+// SetStepLimit makes evaluation abort after the given number of steps,
+// where a step is a function call or an iteration of a loop. A limit of
+// zero means no limit. Setting the limit also resets the step count.
+func (ctx *Context) SetStepLimit(steps uint64) {
+	ctx.steps = 0
+	ctx.maxSteps = steps
 }
 
-func (ctx *Context) pushEnv() { ctx.env = newEnv(ctx.env) }
-func (ctx *Context) popEnv()  { ctx.env = ctx.env.outer }
+// SetStdout sets where puts and print write to (by default, os.Stdout).
+func (ctx *Context) SetStdout(w io.Writer) {
+	ctx.stdout = w
+}
+
+// SetStderr sets where eprint writes to (by default, os.Stderr).
+func (ctx *Context) SetStderr(w io.Writer) {
+	ctx.stderr = w
+}
+
+// SetStdin sets where input reads from (by default, os.Stdin).
+func (ctx *Context) SetStdin(r io.Reader) {
+	ctx.stdin = bufio.NewReader(r)
+}
+
+func (ctx *Context) pushEnv(size int) { ctx.env = newEnv(ctx.env, size) }
+func (ctx *Context) pushModuleEnv()   { ctx.env = newModuleEnv(ctx.env) }
+func (ctx *Context) popEnv()          { ctx.env = ctx.env.outer }
 
 func (ctx *Context) pushFunc(e callStackEntry) { ctx.stack = append(ctx.stack, e) }
 func (ctx *Context) popFunc()                  { ctx.stack = ctx.stack[:len(ctx.stack)-1] }
@@ -57,6 +161,8 @@ func (ctx *Context) EvalStmt(node parser.Stmt) Value {
 		return CONTINUE
 	case *parser.Return:
 		return ctx.evalReturn(node)
+	case *parser.Try:
+		return ctx.evalTry(node)
 	}
 	panic(fmt.Sprintf("unhandled node %#+v", node))
 }
@@ -77,6 +183,10 @@ func (ctx *Context) EvalExpr(node parser.Expr) Value {
 		return ctx.evalGet(node)
 	case *parser.Set:
 		return ctx.evalSet(node)
+	case *parser.Index:
+		return ctx.evalIndex(node)
+	case *parser.SetIndex:
+		return ctx.evalSetIndex(node)
 	case *parser.Method:
 		return ctx.evalMethod(node)
 	case *parser.Call:
@@ -113,37 +223,72 @@ func (ctx *Context) EvalExpr(node parser.Expr) Value {
 // ==========
 
 func (ctx *Context) evalModule(module *parser.Module) Value {
-	ctx.pushEnv()
+	if _, err := ctx.runModule(module); err != nil {
+		if e, ok := err.(*Error); ok {
+			return e
+		}
+		return newError(ctx, String(err.Error()))
+	}
+	return NIL
+}
+
+// runModule evaluates the module in a new module environment, returning
+// whatever its `exports' binding refers to at the end. The error is
+// either SourceErrors from the compiler or an *Error thrown by the module.
+func (ctx *Context) runModule(module *parser.Module) (Value, error) {
+	var code *compiledCode
+	if ctx.compile {
+		c, errs := compiler.Compile(module)
+		if len(errs) != 0 {
+			return nil, SourceErrors(errs)
+		}
+		code = newCompiledCode(c)
+	}
+	ctx.pushModuleEnv()
 	ctx.globals.addToEnv(ctx.env)
+	ctx.env.set("exports", newObject(ctx.globals.Object))
 	ctx.pushFunc(&moduleCse{module.Filename})
+	defer ctx.popEnv()
+	defer ctx.popFunc()
+	if code != nil {
+		if rv := ctx.runCode(code); isError(rv) {
+			return nil, rv.(*Error)
+		}
+		exports, _ := ctx.env.get("exports")
+		return exports, nil
+	}
 	for _, stmt := range module.Stmts {
 		rv := ctx.EvalStmt(stmt)
 		if isError(rv) {
-			return rv
+			return nil, rv.(*Error)
 		}
 	}
-	ctx.popFunc()
-	ctx.popEnv()
-	return NIL
+	exports, _ := ctx.env.get("exports")
+	return exports, nil
 }
 
 func (ctx *Context) evalLet(node *parser.Let) Value {
-	name := node.Name.Lexeme
 	value := ctx.EvalExpr(node.Value)
 	if isError(value) {
 		return value
 	}
-	ctx.env.set(name, value)
+	ctx.env.assign(node.Slot, node.Name.Lexeme, value)
 	return NIL
 }
 
+// evalBlock evaluates the statements in a new environment, stopping at
+// the first signal (error, return, break or continue) and returning it
+// to be handled by the enclosing construct.
 func (ctx *Context) evalBlock(node *parser.Block) Value {
 	var rv = Value(NIL)
-	ctx.pushEnv()
+	ctx.pushEnv(node.Size)
+	defer ctx.popEnv()
 	for _, stmt := range node.Stmts {
 		rv = ctx.EvalStmt(stmt)
+		if isSignal(rv) {
+			return rv
+		}
 	}
-	ctx.popEnv()
 	return rv
 }
 
@@ -152,20 +297,18 @@ func (ctx *Context) evalFor(node *parser.For) Value {
 	if isError(iter_obj) {
 		return iter_obj
 	}
-	iterator, ok := getIterator(iter_obj)
-	if !ok {
-		e := newError(ctx, String("not an iterable"))
-		return ctx.addErrorStack(e, node.Keyword)
+	iterator, err := ctx.getIterator(iter_obj)
+	if err != nil {
+		return ctx.addErrorStack(err, node.Keyword)
 	}
-	loop_var := node.Name.Lexeme
 	loop_rv := Value(NIL)
-	ctx.pushEnv()
+	ctx.pushEnv(1)
 	env := ctx.env
 	for {
 		// while (!it.done())
 		done := iterator.Done()
 		if isError(done) {
-			loop_rv = done
+			loop_rv = ctx.addErrorStack(done.(*Error), node.Keyword)
 			break
 		}
 		if isTruthy(done) {
@@ -174,10 +317,10 @@ func (ctx *Context) evalFor(node *parser.For) Value {
 		// let ? = it.next()
 		next := iterator.Next()
 		if isError(next) {
-			loop_rv = next
+			loop_rv = ctx.addErrorStack(next.(*Error), node.Keyword)
 			break
 		}
-		env.set(loop_var, next)
+		env.slots[0] = next
 		signal := ctx.EvalStmt(node.Stmt)
 		if isBreak(signal) {
 			break
@@ -186,11 +329,18 @@ func (ctx *Context) evalFor(node *parser.For) Value {
 			loop_rv = signal
 			break
 		}
+		if err := ctx.step(); err != nil {
+			loop_rv = err
+			break
+		}
 	}
 	ctx.popEnv()
+	if isAbort(loop_rv) {
+		return loop_rv
+	}
 	// always call the .Close method, to allow for cleanup
-	if v := iterator.Done(); isError(v) {
-		return v
+	if v := iterator.Close(); isError(v) && !isError(loop_rv) {
+		return ctx.addErrorStack(v.(*Error), node.Keyword)
 	}
 	return loop_rv
 }
@@ -211,6 +361,9 @@ func (ctx *Context) evalWhile(node *parser.While) Value {
 		if isError(rv) || isReturn(rv) {
 			return rv
 		}
+		if err := ctx.step(); err != nil {
+			return err
+		}
 	}
 	return NIL
 }
@@ -240,6 +393,57 @@ func (ctx *Context) evalReturn(node *parser.Return) Value {
 	return Return{v}
 }
 
+func (ctx *Context) evalTry(node *parser.Try) Value {
+	rv := ctx.evalBlock(node.Body)
+	if isAbort(rv) {
+		// aborts cannot be caught, and skip finally blocks.
+		return rv
+	}
+	if isError(rv) && node.Catch != nil {
+		ctx.pushEnv(1)
+		ctx.env.slots[0] = ctx.caughtValue(rv.(*Error))
+		rv = ctx.evalBlock(node.Catch)
+		ctx.popEnv()
+		if isAbort(rv) {
+			return rv
+		}
+	}
+	if node.Finally != nil {
+		// signals from the finally block take precedence.
+		signal := ctx.evalBlock(node.Finally)
+		if isSignal(signal) {
+			return signal
+		}
+	}
+	return rv
+}
+
+// caughtValue returns the value bound by a catch clause, i.e. the thrown
+// reason. If the reason is an Error instance, then the stack captured while
+// unwinding is stored in its `stack' slot, as an array of strings.
+func (ctx *Context) caughtValue(err *Error) Value {
+	if obj, ok := err.reason.(*Object); ok && ctx.needsStack(obj) {
+		trace := err.trace()
+		frames := make([]Value, len(trace))
+		for i, frame := range trace {
+			frames[i] = String(frame)
+		}
+		ctx.setSlot(obj, "stack", newArray(ctx, frames))
+	}
+	return err.reason
+}
+
+// needsStack returns whether the stack should be stored on the thrown
+// obj: only Error instances get one, and not prototypes (e.g. from
+// Error.throw()), which would pass it on to every new instance. An
+// existing stack is kept, so that rethrowing keeps the original trace.
+func (ctx *Context) needsStack(obj *Object) bool {
+	return obj != ctx.globals.Error &&
+		!obj.slots.isProto &&
+		obj.slots.get("stack") == nil &&
+		ctx.isA(obj, ctx.globals.Error)
+}
+
 // ===========
 // Expressions
 // ===========
@@ -281,8 +485,7 @@ func (ctx *Context) evalAssign(node *parser.Assign) Value {
 	if isError(right) {
 		return right
 	}
-	name := node.Name.Lexeme
-	ctx.env.ancestor(node.Loc).set(name, right)
+	ctx.env.ancestor(node.Loc).assign(node.Slot, node.Name.Lexeme, right)
 	return right
 }
 
@@ -306,8 +509,7 @@ func (ctx *Context) evalGet(node *parser.Get) Value {
 	if isSuper(object) {
 		object = object.(Super).proto
 	}
-	var tmp Value
-	rv := ctx.getSlot(object, node.Name.Lexeme, &tmp)
+	rv := ctx.cachedGetSlot(nodeCache(&node.Cache), object, node.Name.Lexeme, nil)
 	if isError(rv) {
 		return ctx.addErrorStack(rv.(*Error), node.Name)
 	}
@@ -333,6 +535,45 @@ func (ctx *Context) evalSet(node *parser.Set) Value {
 	return rv
 }
 
+// evalIndex evaluates obj[index] by calling obj.get(index).
+func (ctx *Context) evalIndex(node *parser.Index) Value {
+	object := ctx.EvalExpr(node.Object)
+	if isError(object) {
+		return object
+	}
+	index := ctx.EvalExpr(node.Key)
+	if isError(index) {
+		return index
+	}
+	rv := ctx.call_method(object, "get", []Value{index})
+	if isError(rv) {
+		return ctx.addErrorStack(rv.(*Error), node.LBracket)
+	}
+	return rv
+}
+
+// evalSetIndex evaluates obj[index] = right by calling obj.set(index, right).
+// Like assignments, the expression evaluates to right.
+func (ctx *Context) evalSetIndex(node *parser.SetIndex) Value {
+	right := ctx.EvalExpr(node.Right)
+	if isError(right) {
+		return right
+	}
+	object := ctx.EvalExpr(node.Object)
+	if isError(object) {
+		return object
+	}
+	index := ctx.EvalExpr(node.Key)
+	if isError(index) {
+		return index
+	}
+	rv := ctx.call_method(object, "set", []Value{index, right})
+	if isError(rv) {
+		return ctx.addErrorStack(rv.(*Error), node.LBracket)
+	}
+	return right
+}
+
 func (ctx *Context) evalMethod(node *parser.Method) Value {
 	object := ctx.EvalExpr(node.Object)
 	if isError(object) {
@@ -344,7 +585,7 @@ func (ctx *Context) evalMethod(node *parser.Method) Value {
 		object = object.(Super).proto
 		this = ctx.this
 	}
-	fn := ctx.getSlot(object, node.Name.Lexeme, &whence)
+	fn := ctx.cachedGetSlot(nodeCache(&node.Cache), object, node.Name.Lexeme, &whence)
 	if isError(fn) {
 		return ctx.addErrorStack(fn.(*Error), node.Name)
 	}
@@ -385,8 +626,8 @@ func (ctx *Context) evalCall(node *parser.Call) Value {
 
 func (ctx *Context) evalIdentifier(node *parser.Identifier) Value {
 	name := node.Id.Lexeme
-	value, ok := ctx.env.ancestor(node.Loc).get(name)
-	if !ok {
+	value := ctx.env.ancestor(node.Loc).lookup(node.Slot, name)
+	if value == nil {
 		e := newError(ctx, String(fmt.Sprintf("%q is not defined", name)))
 		return ctx.addErrorStack(e, node.Id)
 	}
@@ -446,18 +687,20 @@ func (ctx *Context) evalSuper(node *parser.Super) Value {
 
 func (ctx *Context) addErrorStack(err *Error, token lexer.Token) *Error {
 	cse := ctx.stack[len(ctx.stack)-1]
-	err.stack = append(err.stack, context{
-		fn:  cse.Filename(),
-		ln:  token.Line,
-		col: token.Column,
-		ctx: cse.Context(),
+	err.stack = append(err.stack, stackFrame{
+		fn:     cse.Filename(),
+		ln:     token.Line,
+		col:    token.Column,
+		endLn:  token.EndLine,
+		endCol: token.EndColumn,
+		ctx:    cse.Context(),
 	})
 	return err
 }
 
 func (ctx *Context) addErrorStackBuiltin(err *Error) *Error {
 	cse := ctx.stack[len(ctx.stack)-1]
-	err.stack = append(err.stack, context{
+	err.stack = append(err.stack, stackFrame{
 		fn:  cse.Filename(),
 		ln:  0, col: 0,
 		ctx: cse.Context(),
@@ -471,3 +714,18 @@ func isBreak(s Value) bool    { return s.Type() == VT_BREAK }
 func isContinue(s Value) bool { return s.Type() == VT_CONTINUE }
 func isReturn(s Value) bool   { return s.Type() == VT_RETURN }
 func isTruthy(s Value) bool   { return s != FALSE && s != NIL }
+
+// isAbort tells us if s is an error which aborts evaluation, see newAbort.
+func isAbort(s Value) bool {
+	err, ok := s.(*Error)
+	return ok && err.abort != nil
+}
+
+// isSignal tells us if s should stop the evaluation of a block.
+func isSignal(s Value) bool {
+	switch s.Type() {
+	case VT_ERROR, VT_RETURN, VT_BREAK, VT_CONTINUE:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUnwindOnError(t *testing.T) {
+	tests := []string{
+		`Object.clone().missing;`,
+		`let f = fn() { Object.clone().missing; }; f();`,
+		`let f = fn() { for (x : [1]) { while (true) { [].get(1); } } }; f();`,
+		`let f = fn() { try { [].pop(); } finally { nil.x; } }; f();`,
+		`if (true) { let x = 1; while (true) { let y = 2; nil.x; } }`,
+	}
+	for i, source := range tests {
+		for _, compile := range []bool{false, true} {
+			ctx := NewContext()
+			ctx.UseCompiler(compile)
+			module, errs := ctx.parseModule("<test>", source)
+			if len(errs) != 0 {
+				t.Fatalf("tests[%d]: unexpected errors: %v", i, errs)
+			}
+			if rv := ctx.EvalStmt(module); !isError(rv) {
+				t.Errorf("tests[%d] (compile=%t): expected an error, got=%#v", i, compile, rv)
+			}
+			if len(ctx.stack) != 0 {
+				t.Errorf("tests[%d] (compile=%t): expected an empty stack, got=%d entries", i, compile, len(ctx.stack))
+			}
+			if ctx.env != nil || ctx.this != nil {
+				t.Errorf("tests[%d] (compile=%t): expected env and this to be unwound", i, compile)
+			}
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	source := `
+let depth = fn(n) {
+	if (n == 0) {
+		return 0;
+	}
+	return 1 + depth(n - 1);
+};
+exports.ok = depth(40);
+exports.err = nil;
+try {
+	depth(50);
+} catch (e) {
+	exports.err = e;
+}`
+	for _, compile := range []bool{false, true} {
+		ctx := NewContext()
+		ctx.UseCompiler(compile)
+		ctx.SetMaxDepth(50)
+		module, errs := ctx.parseModule("<test>", source)
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		rv, err := ctx.runModule(module)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		exports := rv.(*Object)
+		if ok := exports.slots.get("ok"); ok != Number(40) {
+			t.Errorf("(compile=%t) expected ok=40, got=%#v", compile, ok)
+		}
+		if err := exports.slots.get("err"); err != String("maximum recursion depth exceeded") {
+			t.Errorf("(compile=%t) expected a recursion error, got=%#v", compile, err)
+		}
+		if len(ctx.stack) != 0 {
+			t.Errorf("(compile=%t) expected an empty stack, got=%d entries", compile, len(ctx.stack))
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	tests := []struct {
+		source string
+		setup  func(ctx *Context)
+		cause  error
+	}{
+		{`while (true) {}`, func(ctx *Context) { ctx.SetStepLimit(1000) }, ErrStepLimit},
+		{`let f = fn() { return f(); }; f();`, func(ctx *Context) { ctx.SetStepLimit(100) }, ErrStepLimit},
+		{`for (x : [1, 2, 3]) { while (true) {} }`, func(ctx *Context) { ctx.SetStepLimit(1000) }, ErrStepLimit},
+		// aborts can't be caught, and skip finally blocks.
+		{
+			`try { while (true) {} } catch (e) { puts("caught"); } finally { puts("finally"); }`,
+			func(ctx *Context) { ctx.SetStepLimit(1000) },
+			ErrStepLimit,
+		},
+		{
+			`let f = fn() { try { while (true) {} } finally { puts("finally"); } }; try { f(); } catch (e) {}`,
+			func(ctx *Context) { ctx.SetStepLimit(1000) },
+			ErrStepLimit,
+		},
+		{
+			`while (true) {}`,
+			func(ctx *Context) {
+				c, cancel := context.WithCancel(context.Background())
+				cancel()
+				ctx.SetContext(c)
+			},
+			context.Canceled,
+		},
+		{
+			`let f = fn() {}; while (true) { f(); }`,
+			func(ctx *Context) { ctx.SetContext(timeout) },
+			context.DeadlineExceeded,
+		},
+	}
+	for i, test := range tests {
+		for _, compile := range []bool{false, true} {
+			ctx := NewContext()
+			ctx.UseCompiler(compile)
+			test.setup(ctx)
+			module, errs := ctx.parseModule("<test>", test.source)
+			if len(errs) != 0 {
+				t.Fatalf("tests[%d]: unexpected errors: %v", i, errs)
+			}
+			var output bytes.Buffer
+			ctx.SetStdout(&output)
+			exports, err := ctx.runModule(module)
+			if output.Len() != 0 {
+				t.Errorf("tests[%d] (compile=%t): expected no output, got=%q", i, compile, output.String())
+			}
+			if err == nil {
+				t.Errorf("tests[%d] (compile=%t): expected an error, got exports=%#v", i, compile, exports)
+				continue
+			}
+			if e, ok := err.(*Error); !ok || e.Aborted() != test.cause {
+				t.Errorf("tests[%d] (compile=%t): expected cause=%v, got=%v", i, compile, test.cause, err)
+			}
+			if len(ctx.stack) != 0 || ctx.env != nil {
+				t.Errorf("tests[%d] (compile=%t): expected the stack to be unwound", i, compile)
+			}
+		}
+	}
+	// ordinary errors are not aborts.
+	ctx := NewContext()
+	if err := newError(ctx, String("error")); err.Aborted() != nil {
+		t.Errorf("expected Aborted()=nil, got=%v", err.Aborted())
+	}
+}
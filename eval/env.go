@@ -1,11 +1,23 @@
 package eval
 
+// environment holds the variables of a scope. Local scopes store their
+// variables in slots assigned by the resolver; module scopes (where the
+// globals live) are looked up by name instead. A nil slot is a variable
+// which has not been defined yet.
 type environment struct {
+	slots []Value
 	store map[string]Value
 	outer *environment
 }
 
-func newEnv(outer *environment) *environment {
+func newEnv(outer *environment, size int) *environment {
+	return &environment{
+		slots: make([]Value, size),
+		outer: outer,
+	}
+}
+
+func newModuleEnv(outer *environment) *environment {
 	return &environment{
 		store: map[string]Value{},
 		outer: outer,
@@ -28,3 +40,21 @@ func (e *environment) get(name string) (Value, bool) {
 func (e *environment) set(name string, v Value) {
 	e.store[name] = v
 }
+
+// lookup returns the variable in the given slot, or with the given name
+// if slot is -1. It returns nil if the variable is not defined.
+func (e *environment) lookup(slot int, name string) Value {
+	if slot < 0 {
+		return e.store[name]
+	}
+	return e.slots[slot]
+}
+
+// assign is like lookup, but sets the variable.
+func (e *environment) assign(slot int, name string, v Value) {
+	if slot < 0 {
+		e.store[name] = v
+	} else {
+		e.slots[slot] = v
+	}
+}
@@ -0,0 +1,79 @@
+package eval
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Golden tests run every testdata/*.toe script through the whole
+// pipeline, and compare what it prints (plus any errors) against
+// testdata/*.golden. Use `go test ./eval -update' to regenerate them.
+// Every script is run twice: by the evaluator and by the VM, which
+// must behave the same.
+
+var update = flag.Bool("update", false, "update the golden files in testdata/")
+
+func TestGolden(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("testdata", "*.toe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts found in testdata/")
+	}
+	for _, script := range scripts {
+		script := script
+		name := strings.TrimSuffix(filepath.Base(script), ".toe")
+		t.Run(name, func(t *testing.T) {
+			got := runGolden(t, filepath.ToSlash(script), false)
+			golden := strings.TrimSuffix(script, ".toe") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s (run with -update to create it)", err)
+			}
+			if got != string(expected) {
+				t.Errorf("output mismatch for %s\n--- expected ---\n%s\n--- got ---\n%s", script, expected, got)
+			}
+			if got := runGolden(t, filepath.ToSlash(script), true); got != string(expected) {
+				t.Errorf("VM output mismatch for %s\n--- expected ---\n%s\n--- got ---\n%s", script, expected, got)
+			}
+		})
+	}
+}
+
+// runGolden evaluates the given script, returning its output (to both
+// stdout and stderr) followed by any compile or runtime errors.
+func runGolden(t *testing.T, filename string, compile bool) string {
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	ctx := NewContext()
+	ctx.UseCompiler(compile)
+	module, errs := ctx.parseModule(filename, string(source))
+	if len(errs) != 0 {
+		for _, e := range errs {
+			fmt.Fprintln(&buf, e)
+		}
+		return buf.String()
+	}
+	ctx.SetStdout(&buf)
+	ctx.SetStderr(&buf)
+	ctx.SetStdin(strings.NewReader(""))
+	rv := ctx.EvalStmt(module)
+	if isError(rv) {
+		fmt.Fprintln(&buf, rv.(*Error).String())
+	}
+	return buf.String()
+}
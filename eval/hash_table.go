@@ -2,6 +2,7 @@ package eval
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
 	"math"
@@ -10,7 +11,9 @@ import (
 // This file implements a hash table for values.
 // Values which are hashable can be inserted into the table;
 // hashable objects are either one of the hashable builtins,
-// or an object that implements the hash protocol.
+// or an object that implements the hash protocol: a hash()
+// method returning a number, such that a == b implies that
+// a.hash() == b.hash().
 
 func getNewHashTableSeed() uint64 {
 	var b [8]byte
@@ -117,6 +120,7 @@ type hashTable struct {
 	entries []htEntry
 	sz      uint64 // number of non-free entries in the hash table
 	realSz  uint64 // number of non-tombstone, and non-empty entries in the hash table
+	version uint64 // incremented whenever keys are added or removed
 }
 
 func newHashTable(ctx *Context) *hashTable {
@@ -160,6 +164,7 @@ func (ht *hashTable) resize(grow bool) {
 		newSize /= 2
 	}
 	oldEntries := ht.entries
+	ht.version++
 	ht.sz = 0
 	ht.realSz = 0
 	ht.entries = make([]htEntry, newSize)
@@ -261,6 +266,7 @@ func (ht *hashTable) delete(k Value) (found bool, err *Error) {
 	entry.key = nil
 	entry.value = &TOMBSTONE
 	ht.realSz--
+	ht.version++
 	ht.maybeResize()
 	return true, nil
 }
@@ -278,6 +284,7 @@ func (ht *hashTable) insert(k Value, v Value) (err *Error) {
 	}
 	if entry.key == nil {
 		ht.realSz++
+		ht.version++
 		if entry.value != &TOMBSTONE {
 			ht.sz++
 		}
@@ -289,6 +296,14 @@ func (ht *hashTable) insert(k Value, v Value) (err *Error) {
 	return nil
 }
 
+// clear removes all entries from the table.
+func (ht *hashTable) clear() {
+	ht.entries = make([]htEntry, ht_MIN_SIZE)
+	ht.sz = 0
+	ht.realSz = 0
+	ht.version++
+}
+
 func (ht *hashTable) size() uint64 {
 	return ht.realSz
 }
@@ -298,10 +313,43 @@ func (ht *hashTable) size() uint64 {
 // ===============
 
 // getObjectHash calls the object's hash function on v and returns the result.
-// if the result is an error, this needs to be handled.
+// if the result is an error, this needs to be handled. Builtin values are
+// hashed directly; everything else has its hash() slot called.
 func (ctx *Context) getObjectHash(v Value) (hash Value) {
 	if hashable, ok := v.(interface{ Hash() Value }); ok {
 		return hashable.Hash()
 	}
+	var whence Value
+	if fn := ctx.maybeGetSlot(v, "hash", &whence); fn != nil {
+		return ctx.call(whence, fn, v, nil)
+	}
 	return newError(ctx, String(fmt.Sprintf("object %s is not hashable", v.Type())))
 }
+
+// htHashIdentity hashes v by its address.
+func htHashIdentity(v Value) Value {
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("O%p", v)))
+	return htTruncateHash(h.Sum64())
+}
+
+// htHashArray combines the hashes of the given values.
+func (ctx *Context) htHashArray(values []Value) Value {
+	h := fnv.New64a()
+	h.Write([]byte("A"))
+	var b [8]byte
+	for _, v := range values {
+		rv := ctx.getObjectHash(v)
+		if isError(rv) {
+			return rv
+		}
+		if rv.Type() != VT_NUMBER {
+			return newError(ctx, String(fmt.Sprintf(
+				"expected hash to return a number, got: %s",
+				rv.Type())))
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(float64(rv.(Number))))
+		h.Write(b[:])
+	}
+	return htTruncateHash(h.Sum64())
+}
@@ -92,6 +92,57 @@ func TestHashTableKeyTypes(t *testing.T) {
 	}
 }
 
+func TestHashTableObjectKeys(t *testing.T) {
+	ctx := NewContext()
+	ht := newHashTable(ctx)
+	// equal arrays are the same key.
+	mustInsert(t, ht, newArray(ctx, []Value{Number(1), String("x")}), NIL)
+	mustInsert(t, ht, newArray(ctx, []Value{Number(1), String("x")}), TRUE)
+	if v := mustGet(t, ht, newArray(ctx, []Value{Number(1), String("x")})); v != TRUE {
+		t.Fatalf("expected=%#v, got=%#v", TRUE, v)
+	}
+	// objects are hashed by identity.
+	a := newObject(ctx.globals.Object)
+	b := newObject(ctx.globals.Object)
+	mustInsert(t, ht, a, String("a"))
+	mustInsert(t, ht, b, String("b"))
+	if v := mustGet(t, ht, a); v != String("a") {
+		t.Fatalf("expected=%#v, got=%#v", String("a"), v)
+	}
+	if got := ht.size(); got != 3 {
+		t.Fatalf("ht.size(): expected=3, got=%d", got)
+	}
+	// objects with their own hash() and == slots.
+	point := func(x Number) *Object {
+		p := newObject(ctx.globals.Object)
+		p.slots.set("x", x)
+		p.slots.set("hash", newBuiltin("hash", func(ctx *Context, this Value, args []Value) Value {
+			return ctx.maybeGetSlot(this, "x", nil)
+		}))
+		p.slots.set("==", newBuiltin("==", func(ctx *Context, this Value, args []Value) Value {
+			return Boolean(ctx.maybeGetSlot(this, "x", nil) == ctx.maybeGetSlot(args[0], "x", nil))
+		}))
+		return p
+	}
+	mustInsert(t, ht, point(1), String("p"))
+	if v := mustGet(t, ht, point(1)); v != String("p") {
+		t.Fatalf("expected=%#v, got=%#v", String("p"), v)
+	}
+	// errors from hash() are surfaced.
+	bad := newObject(ctx.globals.Object)
+	bad.slots.set("hash", newBuiltin("hash", func(ctx *Context, this Value, args []Value) Value {
+		return String("not a number")
+	}))
+	for i, k := range []Value{bad, newHash(ctx), newArray(ctx, []Value{bad})} {
+		if err := ht.insert(k, NIL); err == nil {
+			t.Errorf("tests[%d]: expected an error inserting %#v", i, k)
+		}
+		if _, _, err := ht.get(k); err == nil {
+			t.Errorf("tests[%d]: expected an error getting %#v", i, k)
+		}
+	}
+}
+
 func mustInsert(t *testing.T, ht *hashTable, k Value, v Value) {
 	if err := ht.insert(k, v); err != nil {
 		t.Fatalf("unexpected insertion error=%#v", err)
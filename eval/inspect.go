@@ -1,57 +1,238 @@
 package eval
 
 import (
-	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-// This file implements the inspect() protocol.
+// This file implements the inspect() and to_string() protocols.
 //
 // inspect() calls inspect_visit(f) with a function used to call the
-// next inspected object.
+// next inspected object. The function keeps track of the values being
+// inspected, so that cycles are shown as `...', and containers nested
+// more than inspectMaxDepth deep are elided (e.g. as `[...]').
+//
+// The builtin prototypes and namespaces (e.g. `Array' and `Math') are
+// shown by name. Other objects without their own inspect or inspect_visit
+// slots are shown with their own slots, labelled with the name of the
+// nearest builtin prototype on their prototype chain, e.g.
+// `Object {legs: 4, name: "fido"}'. Containers which don't fit in
+// inspectWidth characters are split over several lines.
+//
+// to_string() returns the text used when a value is printed or formatted
+// into a string (e.g. by puts, print, format and join). Strings are used
+// as-is, numbers are formatted, and everything else falls back to
+// inspect() unless it has its own to_string slot.
+
+const (
+	inspectWidth    = 72
+	inspectMaxDepth = 6
+)
 
 var bi_Object_inspect = make_method(
 	make_argspec(VT_ANY),
 	func(ctx *Context, this Value, args []Value) Value {
 		outer_this := this
-		m := map[Value]bool{}
+		// the values currently being inspected, i.e. the path from
+		// outer_this to the current value.
+		path := map[Value]bool{}
 		var visitor *Builtin
 		visitor = newBuiltin("visitor", make_method(
 			make_argspec(VT_ANY, make_argpair("value", VT_ANY)),
-			func (ctx *Context, _ Value, args []Value) Value {
+			func(ctx *Context, _ Value, args []Value) Value {
 				v := args[0]
-				if m[v] {
+				if v == NIL {
+					// nil has no prototype, and so no inspect slot.
+					return String("nil")
+				}
+				if path[v] {
 					return String("...")
-				} else {
-					m[v] = true
-					// Check if we have an inspect_visit method; if so then we have
-					// to call it; otherwise just call the normal inspect().
-					var rv Value
-					if ctx.maybeGetSlot(v, "inspect_visit", nil) != nil {
-						rv = ctx.call_method(v, "inspect_visit", []Value{visitor})
-					} else if v != outer_this {
-						rv = ctx.call_method(v, "inspect", nil)
-					} else {
-						rv = String(fmt.Sprintf("[Object %p]", v))
-					}
-					if isError(rv) {
-						ctx.addErrorStackBuiltin(rv.(*Error))
-						return rv
-					}
-					str := ctx.getSpecial(rv, VT_STRING)
-					if str == nil {
-						err := newError(ctx, String("inspect should return a string"))
-						return ctx.addErrorStackBuiltin(err)
+				}
+				if len(path) >= inspectMaxDepth {
+					if elided := ctx.inspectElided(v); elided != "" {
+						return String(elided)
 					}
-					return str
 				}
+				path[v] = true
+				defer delete(path, v)
+				// Check if we have an inspect_visit method; if so then we have
+				// to call it; otherwise just call the normal inspect().
+				var rv Value
+				obj, isObject := v.(*Object)
+				if name := ctx.globalName(v); isObject && name != "" {
+					rv = String(name)
+				} else if ctx.maybeGetSlot(v, "inspect_visit", nil) != nil {
+					rv = ctx.call_method(v, "inspect_visit", []Value{visitor})
+				} else if isObject && (v == outer_this || ctx.hasDefaultInspect(v)) {
+					rv = ctx.inspectObject(obj, visitor)
+				} else if v != outer_this {
+					rv = ctx.call_method(v, "inspect", nil)
+				} else {
+					rv = String(fmt.Sprintf("[Object %p]", v))
+				}
+				if isError(rv) {
+					ctx.addErrorStackBuiltin(rv.(*Error))
+					return rv
+				}
+				str := ctx.getSpecial(rv, VT_STRING)
+				if str == nil {
+					err := newError(ctx, String("inspect should return a string"))
+					return ctx.addErrorStackBuiltin(err)
+				}
+				return str
 			},
 		))
 		return ctx.call(NIL, visitor, NIL, []Value{outer_this})
 	},
 )
 
+// hasDefaultInspect returns whether v's inspect slot is Object.inspect.
+func (ctx *Context) hasDefaultInspect(v Value) bool {
+	return ctx.maybeGetSlot(v, "inspect", nil) == ctx.globals.inspect
+}
+
+// inspectObject shows the object's own slots, using visitor to inspect
+// their values.
+func (ctx *Context) inspectObject(obj *Object, visitor *Builtin) Value {
+	names := obj.slots.names()
+	parts := make([]string, len(names))
+	for i, name := range names {
+		s := ctx.call(NIL, visitor, NIL, []Value{obj.slots.values[i]})
+		if isError(s) {
+			return s
+		}
+		parts[i] = inspectSlotName(name) + ": " + string(s.(String))
+	}
+	return String(inspectJoin(ctx.inspectLabel(obj)+" {", "}", parts))
+}
+
+// inspectElided returns how a container nested too deeply is shown, or
+// "" if v is not a container.
+func (ctx *Context) inspectElided(v Value) string {
+	obj, isObject := v.(*Object)
+	switch {
+	case isObject && ctx.globalName(obj) != "":
+		return ""
+	case ctx.getSpecial(v, VT_ARRAY) != nil:
+		return "[...]"
+	case ctx.getSpecial(v, VT_HASH) != nil:
+		return "{...}"
+	case ctx.maybeGetSlot(v, "inspect_visit", nil) != nil:
+		return "..."
+	}
+	if isObject && ctx.hasDefaultInspect(obj) {
+		return ctx.inspectLabel(obj) + " {...}"
+	}
+	return ""
+}
+
+// inspectLabel returns the name of the nearest builtin prototype on the
+// object's prototype chain.
+func (ctx *Context) inspectLabel(obj *Object) string {
+	for proto := obj.proto; proto != nil; proto = ctx.getPrototype(proto) {
+		if name := ctx.globalName(proto); name != "" {
+			return name
+		}
+	}
+	return "Object"
+}
+
+// globalName returns the name of v if it is one of the builtin
+// prototypes or namespaces (e.g. Array or Math), or "" otherwise.
+func (ctx *Context) globalName(v Value) string {
+	if obj, ok := v.(*Object); ok {
+		return ctx.globals.names[obj]
+	}
+	return ""
+}
+
+func inspectSlotName(name string) string {
+	for i, r := range name {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return strconv.Quote(name)
+		}
+	}
+	if name == "" {
+		return `""`
+	}
+	return name
+}
+
+// inspectJoin joins the inspected parts of a container. It is kept on one
+// line if it fits in inspectWidth characters; otherwise each part is put
+// on its own line, indented.
+func inspectJoin(open, close string, parts []string) string {
+	line := open + strings.Join(parts, ", ") + close
+	if len(parts) == 0 || utf8.RuneCountInString(line) <= inspectWidth && !strings.Contains(line, "\n") {
+		return line
+	}
+	var buf strings.Builder
+	buf.WriteString(open)
+	for i, part := range parts {
+		buf.WriteString("\n  ")
+		buf.WriteString(strings.ReplaceAll(part, "\n", "\n  "))
+		if i != len(parts)-1 {
+			buf.WriteString(",")
+		}
+	}
+	buf.WriteString("\n")
+	buf.WriteString(close)
+	return buf.String()
+}
+
+var bi_Object_to_string = make_method(
+	make_argspec(VT_ANY),
+	func(ctx *Context, this Value, args []Value) Value {
+		rv := ctx.inspectValue(this)
+		if isError(rv) {
+			ctx.addErrorStackBuiltin(rv.(*Error))
+		}
+		return rv
+	},
+)
+
+var bi_String_to_string = make_method(
+	make_argspec(VT_STRING),
+	func(ctx *Context, this Value, args []Value) Value {
+		return this
+	},
+)
+
+// toString returns the result of v.to_string(), which must be a string.
+func (ctx *Context) toString(v Value) Value {
+	if v == NIL {
+		return String("nil")
+	}
+	rv := ctx.call_method(v, "to_string", nil)
+	if isError(rv) {
+		return rv
+	}
+	str := ctx.getSpecial(rv, VT_STRING)
+	if str == nil {
+		return newError(ctx, String("to_string should return a string"))
+	}
+	return str
+}
+
+// inspectValue returns the result of v.inspect(), which must be a string.
+func (ctx *Context) inspectValue(v Value) Value {
+	if v == NIL {
+		return String("nil")
+	}
+	rv := ctx.call_method(v, "inspect", nil)
+	if isError(rv) {
+		return rv
+	}
+	str := ctx.getSpecial(rv, VT_STRING)
+	if str == nil {
+		return newError(ctx, String("inspect should return a string"))
+	}
+	return str
+}
+
 var bi_Function_inspect = make_method(
 	make_argspec(VT_FUNCTION),
 	func(ctx *Context, this Value, args []Value) Value {
@@ -76,36 +257,55 @@ var bi_String_inspect = make_method(
 	},
 )
 
+func formatNumber(n Number) string {
+	return strconv.FormatFloat(float64(n), 'g', -1, 64)
+}
+
 var bi_Number_inspect = make_method(
 	make_argspec(VT_NUMBER),
 	func(ctx *Context, this Value, args []Value) Value {
-		return String(strconv.FormatFloat(
-			float64(this.(Number)),
-			'g', -1, 64,
-		))
+		return String(formatNumber(this.(Number)))
 	},
 )
 
 var bi_Array_inspect_visit = make_method(
 	make_argspec(VT_ARRAY, make_argpair("f", VT_CALL)),
 	func(ctx *Context, this Value, args []Value) Value {
-		f := args[0].(*Builtin)
-		var buf bytes.Buffer
-		buf.WriteString("[")
+		f := args[0]
 		arr := this.(*Array)
-		sz := len(arr.values)
+		parts := make([]string, len(arr.values))
 		for i, x := range arr.values {
 			s := ctx.call(NIL, f, NIL, []Value{x})
 			if isError(s) {
 				ctx.addErrorStackBuiltin(s.(*Error))
 				return s
 			}
-			buf.WriteString(string(s.(String)))
-			if i != sz - 1 {
-				buf.WriteString(", ")
+			parts[i] = string(s.(String))
+		}
+		return String(inspectJoin("[", "]", parts))
+	},
+)
+
+var bi_Hash_inspect_visit = make_method(
+	make_argspec(VT_HASH, make_argpair("f", VT_CALL)),
+	func(ctx *Context, this Value, args []Value) Value {
+		f := args[0]
+		parts := []string{}
+		for _, entry := range this.(*Hash).table.entries {
+			if !entry.hasValue() {
+				continue
+			}
+			var kv [2]string
+			for i, x := range []Value{*entry.key, *entry.value} {
+				s := ctx.call(NIL, f, NIL, []Value{x})
+				if isError(s) {
+					ctx.addErrorStackBuiltin(s.(*Error))
+					return s
+				}
+				kv[i] = string(s.(String))
 			}
+			parts = append(parts, kv[0]+": "+kv[1])
 		}
-		buf.WriteString("]")
-		return String(buf.String())
+		return String(inspectJoin("{", "}", parts))
 	},
 )
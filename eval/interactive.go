@@ -1,6 +1,10 @@
 package eval
 
 import (
+	"context"
+	"strings"
+	"toe/compiler"
+	"toe/diag"
 	"toe/lexer"
 	"toe/parser"
 	"toe/resolver"
@@ -10,34 +14,65 @@ type InteractiveContext struct {
 	Filename string
 	ctx      *Context
 	res      *resolver.Resolver
+	// everything run so far, so that lines are numbered (and errors
+	// are shown) as if the inputs were one file.
+	input strings.Builder
+	lines int
 }
 
 func NewInteractiveContext() *InteractiveContext {
 	fn := "<stdin>"
 	module := &parser.Module{Filename: fn}
-	res := resolver.New(module)
 	ctx := NewContext()
-	ctx.globals.addToResolver(res)
-	ctx.pushEnv()
+	res := ctx.NewResolver(module)
+	ctx.pushModuleEnv()
 	ctx.globals.addToEnv(ctx.env)
+	ctx.env.set("exports", newObject(ctx.globals.Object))
 	ctx.pushFunc(&moduleCse{fn})
-	return &InteractiveContext{fn, ctx, res}
+	return &InteractiveContext{Filename: fn, ctx: ctx, res: res}
+}
+
+// SetContext sets the context.Context used to abort statements, see
+// Context.SetContext.
+func (ic *InteractiveContext) SetContext(c context.Context) {
+	ic.ctx.SetContext(c)
 }
 
 func (ic *InteractiveContext) Inspect(v Value) (string, *Error) {
-	rv := ic.ctx.call_method(v, "inspect", nil)
+	rv := ic.ctx.inspectValue(v)
 	if isError(rv) {
 		return "", rv.(*Error)
 	}
-	str := ic.ctx.getSpecial(rv, VT_STRING)
-	if str == nil {
-		return "", newError(ic.ctx, String("inspect returned a non-string"))
-	}
 	return string(rv.(String)), nil
 }
 
+// SetGlobal defines a global, see Context.SetGlobal. It is also visible
+// to statements run afterwards.
+func (ic *InteractiveContext) SetGlobal(name string, value Value) {
+	ic.ctx.SetGlobal(name, value)
+	ic.ctx.env.set(name, value)
+	ic.res.AddGlobals([]string{name})
+}
+
+// FormatError returns an error from Run for display, see
+// Context.FormatError.
+func (ic *InteractiveContext) FormatError(err error) string {
+	return ic.ctx.FormatError(err)
+}
+
+// Reports returns an error from Run in machine-readable form, see
+// Context.Reports.
+func (ic *InteractiveContext) Reports(err error) []diag.Report {
+	return ic.ctx.Reports(err)
+}
+
 func (ic *InteractiveContext) Run(input string) (Value, []error) {
 	l := lexer.New(ic.Filename, input)
+	l.SetLine(ic.lines + 1)
+	ic.input.WriteString(input)
+	ic.input.WriteString("\n")
+	ic.lines += strings.Count(input, "\n") + 1
+	ic.ctx.sources[ic.Filename] = ic.input.String()
 	l.ScanTokens()
 	if len(l.Errors) != 0 {
 		return nil, l.Errors
@@ -55,8 +90,18 @@ func (ic *InteractiveContext) Run(input string) (Value, []error) {
 			return nil, og
 		}
 	}
-	rv := Value(nil)
+	if len(module.Stmts) == 0 {
+		return nil, nil
+	}
 	// Still no errors? we can run it.
+	if ic.ctx.compile {
+		code, errs := compiler.CompileInteractive(module)
+		if len(errs) != 0 {
+			return nil, errs
+		}
+		return ic.ctx.runCode(newCompiledCode(code)), nil
+	}
+	rv := Value(nil)
 	for _, stmt := range module.Stmts {
 		rv = ic.ctx.EvalStmt(stmt)
 		if isError(rv) {
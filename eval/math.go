@@ -0,0 +1,153 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// ====
+// Math
+// ====
+//
+// Math is a plain object holding the usual numeric functions and
+// constants, e.g. Math.sqrt(2), Math.pi. Math.random() and friends use
+// a per-context PRNG, which can be made deterministic with Math.seed(n).
+
+func newMath(g *Globals) *Object {
+	m := newObject(g.Object)
+	m.slots.set("pi", Number(math.Pi))
+	m.slots.set("e", Number(math.E))
+	m.slots.set("inf", Number(math.Inf(1)))
+	m.slots.set("nan", Number(math.NaN()))
+	for name, f := range map[string]func(float64) float64{
+		"floor": math.Floor,
+		"ceil":  math.Ceil,
+		"round": math.Round,
+		"trunc": math.Trunc,
+		"abs":   math.Abs,
+		"sqrt":  math.Sqrt,
+		"exp":   math.Exp,
+		"log":   math.Log,
+		"log2":  math.Log2,
+		"log10": math.Log10,
+		"sin":   math.Sin,
+		"cos":   math.Cos,
+		"tan":   math.Tan,
+		"asin":  math.Asin,
+		"acos":  math.Acos,
+		"atan":  math.Atan,
+	} {
+		m.slots.set(name, newBuiltin(name, mathFunc1(f)))
+	}
+	m.slots.set("atan2", newBuiltin("atan2", mathFunc2(math.Atan2)))
+	m.slots.set("pow", newBuiltin("pow", mathFunc2(math.Pow)))
+	m.slots.set("min", newBuiltin("min", bi_Math_min))
+	m.slots.set("max", newBuiltin("max", bi_Math_max))
+	m.slots.set("random", newBuiltin("random", bi_Math_random))
+	m.slots.set("random_int", newBuiltin("random_int", bi_Math_random_int))
+	m.slots.set("seed", newBuiltin("seed", bi_Math_seed))
+	return m
+}
+
+func mathFunc1(f func(float64) float64) BuiltinFunc {
+	return make_method(
+		make_argspec(VT_ANY, make_argpair("x", VT_NUMBER)),
+		func(ctx *Context, this Value, args []Value) Value {
+			return Number(f(float64(args[0].(Number))))
+		},
+	)
+}
+
+func mathFunc2(f func(float64, float64) float64) BuiltinFunc {
+	return make_method(
+		make_argspec(VT_ANY, make_argpair("x", VT_NUMBER), make_argpair("y", VT_NUMBER)),
+		func(ctx *Context, this Value, args []Value) Value {
+			return Number(f(float64(args[0].(Number)), float64(args[1].(Number))))
+		},
+	)
+}
+
+func bi_Math_min(ctx *Context, this Value, args []Value) Value {
+	return mathFold(ctx, args, math.Min)
+}
+
+func bi_Math_max(ctx *Context, this Value, args []Value) Value {
+	return mathFold(ctx, args, math.Max)
+}
+
+// mathFold folds f over one or more number arguments.
+func mathFold(ctx *Context, args []Value, f func(float64, float64) float64) Value {
+	if len(args) == 0 {
+		err := newError(ctx, String("expected at least 1 argument(s), got=0"))
+		ctx.addErrorStackBuiltin(err)
+		return err
+	}
+	var rv float64
+	for i, arg := range args {
+		n, err := expectArgType(ctx, fmt.Sprintf("%d", i), arg, VT_NUMBER)
+		if err != nil {
+			ctx.addErrorStackBuiltin(err)
+			return err
+		}
+		if i == 0 {
+			rv = float64(n.(Number))
+		} else {
+			rv = f(rv, float64(n.(Number)))
+		}
+	}
+	return Number(rv)
+}
+
+// Math.random() returns a number in [0, 1).
+var bi_Math_random = make_method(
+	make_argspec(VT_ANY),
+	func(ctx *Context, this Value, args []Value) Value {
+		return Number(ctx.globals.rng.Float64())
+	},
+)
+
+// Math.random_int(lo, hi) returns an integer in [lo, hi).
+var bi_Math_random_int = make_method(
+	make_argspec(VT_ANY, make_argpair("lo", VT_NUMBER), make_argpair("hi", VT_NUMBER)),
+	func(ctx *Context, this Value, args []Value) Value {
+		// toInt64 fails for fractions, NaN and infinities.
+		lo, ok1 := toInt64(args[0].(Number))
+		hi, ok2 := toInt64(args[1].(Number))
+		if !ok1 || !ok2 {
+			return newError(ctx, String("bounds must be finite integers"))
+		}
+		if lo >= hi {
+			return newError(ctx, String("empty range for random_int"))
+		}
+		return Number(randomInt(ctx.globals.rng, lo, hi))
+	},
+)
+
+// randomInt returns an integer in [lo, hi), which must not be empty. The
+// width of the range may not fit in an int64, so it is computed as a
+// uint64.
+func randomInt(rng *rand.Rand, lo, hi int64) int64 {
+	width := uint64(hi) - uint64(lo)
+	if width <= math.MaxInt64 {
+		return lo + rng.Int63n(int64(width))
+	}
+	// width > 2^63, so at least half of the draws are in range.
+	for {
+		if x := rng.Uint64(); x < width {
+			return int64(uint64(lo) + x)
+		}
+	}
+}
+
+var bi_Math_seed = make_method(
+	make_argspec(VT_ANY, make_argpair("n", VT_NUMBER)),
+	func(ctx *Context, this Value, args []Value) Value {
+		n, ok := toInt64(args[0].(Number))
+		if !ok {
+			return newError(ctx, String("seed must be an integer"))
+		}
+		ctx.globals.rng.Seed(n)
+		return NIL
+	},
+)
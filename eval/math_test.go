@@ -0,0 +1,120 @@
+package eval
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestNumberOperators(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected Number
+	}{
+		{"7 % 3", 1},
+		{"-7 % 3", 2},
+		{"7 % -3", -2},
+		{"7.5 % 2", 1.5},
+		{"7 ~/ 2", 3},
+		{"-7 ~/ 2", -4},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"2 ** -1", 0.5},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"1 + 2 << 1", 6},
+		{"(2.5).floor() + (2.5).ceil() + (2.5).round()", 8},
+		{"Math.max(1, 5, 3) - Math.min(4, 2)", 3},
+		{"Math.sqrt(16) + Math.abs(-1)", 5},
+	}
+	for i, test := range tests {
+		rv := evalExports(t, "exports.x = "+test.expr+";")
+		if x := rv.(*Object).slots.get("x"); x != test.expected {
+			t.Errorf("tests[%d] %s: expected=%v, got=%#v", i, test.expr, test.expected, x)
+		}
+	}
+}
+
+func TestNumberOperatorErrors(t *testing.T) {
+	tests := []string{
+		"1 % 0",
+		"1 ~/ 0",
+		"1.5 & 1",
+		"1 | 0.5",
+		"~1.5",
+		"1 << -1",
+		"Math.max()",
+		"Math.random_int(1, 1)",
+		"Math.random_int(0.5, 1)",
+		"Math.random_int(0, Math.inf)",
+		"Math.random_int(Math.nan, 1)",
+	}
+	for i, expr := range tests {
+		ctx := NewContext()
+		dir := writeModules(t, map[string]string{"main.toe": expr + ";"})
+		if rv := ctx.requireModule("", filepath.Join(dir, "main.toe")); !isError(rv) {
+			t.Errorf("tests[%d] %s: expected an error, got=%#v", i, expr, rv)
+		}
+	}
+}
+
+func TestMathRandomSeed(t *testing.T) {
+	rv := evalExports(t, `
+let draw = fn() {
+	return [Math.random(), Math.random_int(0, 1000000)];
+};
+Math.seed(42);
+let a = draw();
+Math.seed(42);
+exports.same = a == draw();
+exports.nan = Math.nan.is_nan();
+`)
+	obj := rv.(*Object)
+	if obj.slots.get("same") != TRUE {
+		t.Errorf("expected the same sequence after re-seeding")
+	}
+	if obj.slots.get("nan") != TRUE {
+		t.Errorf("expected Math.nan.is_nan() to be true")
+	}
+	if !math.IsInf(float64(newMath(newGlobals()).slots.get("inf").(Number)), 1) {
+		t.Errorf("expected Math.inf to be +Inf")
+	}
+}
+
+func TestMathRandomIntWideRange(t *testing.T) {
+	rv := evalExports(t, `
+let lo = -9000000000000000000;
+let hi = 9000000000000000000;
+exports.ok = true;
+let i = 0;
+while (i < 100) {
+	let n = Math.random_int(lo, hi);
+	if (n < lo) {
+		exports.ok = false;
+	}
+	if (n >= hi) {
+		exports.ok = false;
+	}
+	i = i + 1;
+}
+`)
+	if ok := rv.(*Object).slots.get("ok"); ok != TRUE {
+		t.Errorf("expected numbers in range, got ok=%#v", ok)
+	}
+}
+
+func evalExports(t *testing.T, source string) Value {
+	t.Helper()
+	ctx := NewContext()
+	dir := writeModules(t, map[string]string{"main.toe": source})
+	rv := ctx.requireModule("", filepath.Join(dir, "main.toe"))
+	if isError(rv) {
+		t.Fatalf("unexpected error: %s", rv.(*Error).String())
+	}
+	return rv
+}
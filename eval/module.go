@@ -0,0 +1,144 @@
+package eval
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"toe/lexer"
+	"toe/parser"
+)
+
+// ======
+// Module
+// ======
+//
+// require(path) loads another file and evaluates it in its own module
+// environment. Every module gets a fresh `exports' object; whatever
+// `exports' is bound to once the module finishes is what require()
+// returns:
+//
+//      // point.toe                | // main.toe
+//      exports.Point = ...;        | let point = require("point.toe");
+//                                  | point.Point.new(1, 2);
+//
+// Modules are cached by their canonical path, so requiring the same file
+// twice evaluates it only once. Requiring a module which is still being
+// loaded is an import cycle, and is reported as an error.
+
+type moduleEntry struct {
+	exports Value
+	loading bool
+}
+
+// requireModule loads the module at the given path, relative to the file
+// of the caller `whence'.
+func (ctx *Context) requireModule(whence string, path string) Value {
+	if !filepath.IsAbs(path) && whence != "" && !strings.HasPrefix(whence, "<") {
+		path = filepath.Join(filepath.Dir(whence), path)
+	}
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return newError(ctx, String(fmt.Sprintf("cannot require %q: %s", path, err)))
+	}
+	if entry, ok := ctx.modules[canonical]; ok {
+		if entry.loading {
+			return newError(ctx, String(ctx.importCycle(canonical)))
+		}
+		return entry.exports
+	}
+	entry := &moduleEntry{loading: true}
+	ctx.modules[canonical] = entry
+	ctx.loading = append(ctx.loading, canonical)
+	rv := ctx.loadModule(canonical)
+	ctx.loading = ctx.loading[:len(ctx.loading)-1]
+	if isError(rv) {
+		// allow the module to be required again.
+		delete(ctx.modules, canonical)
+		return rv
+	}
+	entry.loading = false
+	entry.exports = rv
+	return rv
+}
+
+// loadModule reads, parses, resolves and evaluates the given file,
+// returning its exports.
+func (ctx *Context) loadModule(filename string) Value {
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return newError(ctx, String(fmt.Sprintf("cannot require %q: %s", filename, err)))
+	}
+	module, errs := ctx.parseModule(filename, string(source))
+	if len(errs) != 0 {
+		return ctx.requireError(filename, errs)
+	}
+	// modules don't see the environment of whoever required them.
+	old_env := ctx.env
+	ctx.env = nil
+	exports, err := ctx.runModule(module)
+	ctx.env = old_env
+	if errs, ok := err.(SourceErrors); ok {
+		return ctx.requireError(filename, errs)
+	} else if err != nil {
+		return err.(*Error)
+	}
+	return exports
+}
+
+// requireError returns the error for a module which failed to parse,
+// resolve or compile.
+func (ctx *Context) requireError(filename string, errs []error) *Error {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return newError(ctx, String(fmt.Sprintf(
+		"cannot require %q:\n%s",
+		filename,
+		strings.Join(msgs, "\n"),
+	)))
+}
+
+// parseModule runs the lexer, parser and resolver over source.
+func (ctx *Context) parseModule(filename string, source string) (*parser.Module, []error) {
+	ctx.sources[filename] = source
+	l := lexer.New(filename, source)
+	l.ScanTokens()
+	if len(l.Errors) != 0 {
+		return nil, l.Errors
+	}
+	p := parser.New(filename, l.Tokens)
+	module := p.Parse()
+	if len(p.Errors) != 0 {
+		return nil, p.Errors
+	}
+	r := ctx.NewResolver(module)
+	r.Resolve()
+	if len(r.Errors) != 0 {
+		return nil, r.Errors
+	}
+	r.Cleanup()
+	return module, nil
+}
+
+func (ctx *Context) importCycle(canonical string) string {
+	start := 0
+	for i, fn := range ctx.loading {
+		if fn == canonical {
+			start = i
+			break
+		}
+	}
+	cycle := append([]string{}, ctx.loading[start:]...)
+	cycle = append(cycle, canonical)
+	return fmt.Sprintf("import cycle: %s", strings.Join(cycle, " -> "))
+}
+
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
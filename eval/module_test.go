@@ -0,0 +1,94 @@
+package eval
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequire(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"main.toe": `
+let a = require("a.toe");
+let again = require("./a.toe");
+exports.x = a.x + again.x;
+exports.same = a == again;
+`,
+		"a.toe": `
+exports.x = require("lib/b.toe").x;
+`,
+		"lib/b.toe": `
+exports = { "unused": nil };
+exports.x = 21;
+`,
+	})
+	for _, compile := range []bool{false, true} {
+		ctx := NewContext()
+		ctx.UseCompiler(compile)
+		rv := ctx.requireModule("", filepath.Join(dir, "main.toe"))
+		if isError(rv) {
+			t.Fatalf("unexpected error: %s", rv.(*Error).String())
+		}
+		if x := ctx.maybeGetSlot(rv, "x", nil); x != Number(42) {
+			t.Errorf("expected exports.x=42, got=%#v", x)
+		}
+		if same := ctx.maybeGetSlot(rv, "same", nil); same != TRUE {
+			t.Errorf("expected modules to be cached, got=%#v", same)
+		}
+		if len(ctx.modules) != 3 {
+			t.Errorf("expected 3 cached modules, got=%d", len(ctx.modules))
+		}
+	}
+}
+
+func TestRequireErrors(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"cycle_a.toe": `require("cycle_b.toe");`,
+		"cycle_b.toe": `require("cycle_a.toe");`,
+		"syntax.toe":  `let = 1;`,
+		"runtime.toe": `undefined_fn();`,
+	})
+	tests := []struct {
+		file     string
+		contains string
+	}{
+		{"cycle_a.toe", "import cycle: "},
+		{"syntax.toe", "expect an identifier"},
+		{"runtime.toe", "undefined variable"},
+		{"missing.toe", "cannot require"},
+	}
+	for i, test := range tests {
+		ctx := NewContext()
+		rv := ctx.requireModule("", filepath.Join(dir, test.file))
+		if !isError(rv) {
+			t.Errorf("tests[%d] (%s): expected an error, got=%#v", i, test.file, rv)
+			continue
+		}
+		if msg := rv.(*Error).String(); !strings.Contains(msg, test.contains) {
+			t.Errorf("tests[%d] (%s): expected %q in error, got=%q", i, test.file, test.contains, msg)
+		}
+		if len(ctx.loading) != 0 || len(ctx.modules) != 0 {
+			t.Errorf("tests[%d] (%s): failed modules should not be cached", i, test.file)
+		}
+	}
+}
+
+func writeModules(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "toe-require")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
@@ -2,6 +2,7 @@ package eval
 
 import (
 	"fmt"
+	"toe/diag"
 	"toe/lexer"
 	"unicode/utf8"
 )
@@ -18,8 +19,13 @@ import (
 //                  |   ...
 //                  | }
 //                  | it.close()
+//
+// The builtin iterators (returned by String, Array and Hash's iter()) are
+// driven directly from Go; any other object returned by iter() has its
+// done(), next() and close() methods called. close() is optional.
 
 type Iterator interface {
+	Value
 	Close() Value
 	Done() Value
 	Next() Value
@@ -30,8 +36,9 @@ type StringIterator struct {
 	s String
 }
 
-func (si *StringIterator) Close() Value { return NIL }
-func (si *StringIterator) Done() Value  { return Boolean(si.i >= len(si.s)) }
+func (si *StringIterator) Type() ValueType { return VT_ITERATOR }
+func (si *StringIterator) Close() Value    { return NIL }
+func (si *StringIterator) Done() Value     { return Boolean(si.i >= len(si.s)) }
 func (si *StringIterator) Next() Value {
 	r, w := utf8.DecodeRuneInString(string(si.s)[si.i:])
 	si.i += w
@@ -43,44 +50,91 @@ type ArrayIterator struct {
 	a *Array
 }
 
-func (ai *ArrayIterator) Close() Value { return NIL }
-func (ai *ArrayIterator) Done() Value  { return Boolean(ai.i >= len(ai.a.values)) }
+func (ai *ArrayIterator) Type() ValueType { return VT_ITERATOR }
+func (ai *ArrayIterator) Close() Value    { return NIL }
+func (ai *ArrayIterator) Done() Value     { return Boolean(ai.i >= len(ai.a.values)) }
 func (ai *ArrayIterator) Next() Value {
 	rv := ai.a.values[ai.i]
 	ai.i++
 	return rv
 }
 
+// HashIterator iterates over the keys of a hash, in table order.
+// Adding or removing keys while iterating is an error.
 type HashIterator struct {
-	curr  int
-	valid uint64
-	hash  *Hash
+	curr    int
+	version uint64
+	hash    *Hash
+}
+
+func newHashIterator(hash *Hash) *HashIterator {
+	return &HashIterator{version: hash.table.version, hash: hash}
 }
 
-func (hi *HashIterator) Close() Value { return NIL }
-func (hi *HashIterator) Done() Value  { return Boolean(hi.valid == hi.hash.table.size()) }
+func (hi *HashIterator) Type() ValueType { return VT_ITERATOR }
+func (hi *HashIterator) Close() Value    { return NIL }
+func (hi *HashIterator) Done() Value {
+	ht := hi.hash.table
+	if hi.version != ht.version {
+		return newError(ht.ctx, String("hash modified during iteration"))
+	}
+	for hi.curr < len(ht.entries) && !ht.entries[hi.curr].hasValue() {
+		hi.curr++
+	}
+	return Boolean(hi.curr >= len(ht.entries))
+}
 func (hi *HashIterator) Next() Value {
 	ht := hi.hash.table
-	for i := hi.curr; i < len(ht.entries); i++ {
-		entry := &ht.entries[i]
+	if hi.version != ht.version {
+		return newError(ht.ctx, String("hash modified during iteration"))
+	}
+	for ; hi.curr < len(ht.entries); hi.curr++ {
+		entry := &ht.entries[hi.curr]
 		if entry.hasValue() {
-			hi.valid++
+			hi.curr++
 			return *entry.key
 		}
 	}
 	return NIL
 }
 
-func getIterator(v Value) (Iterator, bool) {
-	switch v := v.(type) {
-	case String:
-		return &StringIterator{s: v}, true
-	case *Array:
-		return &ArrayIterator{a: v}, true
-	case *Hash:
-		return &HashIterator{hash: v}, true
+// objectIterator drives a user-defined iterator object.
+type objectIterator struct {
+	ctx *Context
+	obj Value
+}
+
+func (oi *objectIterator) Type() ValueType { return VT_ITERATOR }
+func (oi *objectIterator) Done() Value     { return oi.ctx.call_method(oi.obj, "done", nil) }
+func (oi *objectIterator) Next() Value     { return oi.ctx.call_method(oi.obj, "next", nil) }
+func (oi *objectIterator) Close() Value {
+	if oi.ctx.maybeGetSlot(oi.obj, "close", nil) == nil {
+		return NIL
+	}
+	return oi.ctx.call_method(oi.obj, "close", nil)
+}
+
+func newIterator(ctx *Context, it Iterator) *Object {
+	obj := newObject(ctx.globals.Iterator)
+	obj.data = it
+	return obj
+}
+
+// getIterator calls obj.iter(), and returns an Iterator driving the result.
+func (ctx *Context) getIterator(obj Value) (Iterator, *Error) {
+	var whence Value
+	iter := ctx.maybeGetSlot(obj, "iter", &whence)
+	if iter == nil {
+		return nil, newError(ctx, String("not an iterable"))
+	}
+	rv := ctx.call(whence, iter, obj, nil)
+	if isError(rv) {
+		return nil, rv.(*Error)
 	}
-	return nil, false
+	if it := ctx.getSpecial(rv, VT_ITERATOR); it != nil {
+		return it.(Iterator), nil
+	}
+	return &objectIterator{ctx, rv}, nil
 }
 
 // ============
@@ -106,6 +160,7 @@ func (f *Function) Bind(this Value) *Function {
 		return f
 	}
 	g := newFunction(f.filename, f.node, f.closure)
+	g.code = f.code
 	g.this = this
 	return g
 }
@@ -114,7 +169,7 @@ func (b *Builtin) Bind(this Value) *Builtin {
 	if b.this != nil {
 		return b
 	}
-	return &Builtin{slots: newSlots(), this: this, call: b.call}
+	return &Builtin{this: this, call: b.call}
 }
 
 // -------------
@@ -167,6 +222,18 @@ func (ctx *Context) getSpecial(obj Value, typ ValueType) Value {
 	return nil
 }
 
+// isA returns whether query appears anywhere on obj's prototype chain
+// (including obj itself).
+func (ctx *Context) isA(obj Value, query Value) bool {
+	for obj != nil {
+		if obj == query {
+			return true
+		}
+		obj = ctx.getPrototype(obj)
+	}
+	return false
+}
+
 // --------
 // Get Slot
 // --------
@@ -176,7 +243,7 @@ func (ctx *Context) getSpecial(obj Value, typ ValueType) Value {
 func (ctx *Context) maybeGetSlot(obj Value, name string, whence *Value) Value {
 	for obj != nil {
 		if obj_slots, ok := obj.(hasSlots); ok {
-			if v, ok := obj_slots.getSlots()[name]; ok {
+			if v := obj_slots.getSlots().get(name); v != nil {
 				if whence != nil {
 					*whence = obj
 				}
@@ -193,17 +260,32 @@ func (ctx *Context) maybeGetSlot(obj Value, name string, whence *Value) Value {
 func (ctx *Context) getSlot(obj Value, name string, whence *Value) Value {
 	rv := ctx.maybeGetSlot(obj, name, whence)
 	if rv == nil {
-		err := newError(ctx, String(fmt.Sprintf("object has no slot %q", name)))
-		return err
+		return ctx.noSlotError(obj, name)
 	}
 	return rv
 }
 
-type hasSlots interface{ getSlots() map[string]Value }
+// noSlotError returns the error for a missing slot $obj.$name, suggesting
+// the closest slot name along the prototype chain.
+func (ctx *Context) noSlotError(obj Value, name string) Value {
+	msg := fmt.Sprintf("object has no slot %q", name)
+	names := []string{}
+	for ; obj != nil; obj = ctx.getPrototype(obj) {
+		if obj_slots, ok := obj.(hasSlots); ok {
+			names = append(names, obj_slots.getSlots().names()...)
+		}
+	}
+	if s := diag.Suggest(name, names); s != "" {
+		msg += fmt.Sprintf(" (did you mean %q?)", s)
+	}
+	return newError(ctx, String(msg))
+}
+
+type hasSlots interface{ getSlots() *slotStore }
 
-func (o *Object) getSlots() map[string]Value   { return o.slots }
-func (f *Function) getSlots() map[string]Value { return f.slots }
-func (b *Builtin) getSlots() map[string]Value  { return b.slots }
+func (o *Object) getSlots() *slotStore   { return &o.slots }
+func (f *Function) getSlots() *slotStore { return &f.slots }
+func (b *Builtin) getSlots() *slotStore  { return &b.slots }
 
 // --------
 // Set Slot
@@ -212,7 +294,10 @@ func (b *Builtin) getSlots() map[string]Value  { return b.slots }
 func (ctx *Context) setSlot(obj Value, name string, val Value) Value {
 	if obj_slots, ok := obj.(hasSlots); ok {
 		slots := obj_slots.getSlots()
-		slots[name] = val
+		if slots.set(name, val) && slots.isProto {
+			// cached lookups through this prototype may now be wrong.
+			ctx.protoEpoch++
+		}
 		return val
 	}
 	err := newError(ctx, String(fmt.Sprintf("cannot set slot %q on object", name)))
@@ -238,52 +323,102 @@ func (ctx *Context) call(whence Value, callee Value, this Value, args []Value) (
 	return
 }
 
+// errMaxDepth returns an error if calling another function would exceed
+// the context's maximum call depth.
+func (ctx *Context) errMaxDepth() *Error {
+	if len(ctx.stack) >= ctx.maxDepth {
+		return newError(ctx, String("maximum recursion depth exceeded"))
+	}
+	return nil
+}
+
+// step counts a function call or loop iteration against the step limit,
+// returning an error if evaluation should be aborted.
+func (ctx *Context) step() *Error {
+	ctx.steps++
+	if ctx.maxSteps != 0 && ctx.steps > ctx.maxSteps {
+		return newAbort(ctx, ErrStepLimit)
+	}
+	if ctx.goctx != nil && ctx.steps%256 == 0 {
+		if err := ctx.goctx.Err(); err != nil {
+			return newAbort(ctx, err)
+		}
+	}
+	return nil
+}
+
 func (f *Function) Call(ctx *Context, this Value, args []Value) Value {
+	if err := ctx.errMaxDepth(); err != nil {
+		return err
+	}
+	if err := ctx.step(); err != nil {
+		return err
+	}
 	old_env := ctx.env
 	old_this := ctx.this
 	if f.this != nil {
 		this = f.this
 	}
 
-	ctx.env = f.closure
+	// The function's environment is laid out as [this, params...].
+	params := f.node.Params
+	ctx.env = newEnv(f.closure, 1+len(params))
 	ctx.this = this
-	ctx.pushEnv()
 	ctx.pushFunc(&functionCse{f})
-
-	ctx.env.set("this", this)
-	for i, id := range f.node.Params {
-		name := id.Lexeme
+	defer func() {
+		ctx.popFunc()
+		ctx.this = old_this
+		ctx.env = old_env
+	}()
+
+	slots := ctx.env.slots
+	slots[0] = this
+	for i := range params {
 		if len(args) <= i {
-			ctx.env.set(name, NIL)
+			slots[i+1] = NIL
 		} else {
-			ctx.env.set(name, args[i])
+			slots[i+1] = args[i]
 		}
 	}
 
+	if f.code != nil {
+		return ctx.runCode(f.code)
+	}
 	// Remember to unwrap return values.
 	rv := ctx.evalBlock(f.node.Body)
 	if isReturn(rv) {
 		rv = rv.(Return).value
 	}
-
-	ctx.popFunc()
-	ctx.popEnv()
-	ctx.this = old_this
-	ctx.env = old_env
 	return rv
 }
 
-func (b *Builtin) Call(ctx *Context, this Value, args []Value) Value {
+// Call calls the builtin. A Go panic in the builtin (e.g. a bound Go
+// function) is recovered and returned as an error, so that scripts cannot
+// crash the host.
+func (b *Builtin) Call(ctx *Context, this Value, args []Value) (rv Value) {
+	if err := ctx.errMaxDepth(); err != nil {
+		return err
+	}
 	old_this := ctx.this
 	if b.this != nil {
 		this = b.this
 	}
+	depth, env, whence := len(ctx.stack), ctx.env, ctx.whence
 	ctx.pushFunc(&builtinCse{b})
 	ctx.this = this
-	rv := b.call(ctx, this, args)
-	ctx.this = old_this
-	ctx.popFunc()
-	return rv
+	defer func() {
+		if r := recover(); r != nil {
+			// the builtin may have panicked while calling back into
+			// ctx, so unwind whatever it left behind.
+			ctx.stack = ctx.stack[:depth+1]
+			ctx.env, ctx.whence = env, whence
+			err := newError(ctx, String(fmt.Sprintf("%s panicked: %v", b.name, r)))
+			rv = ctx.addErrorStackBuiltin(err)
+		}
+		ctx.this = old_this
+		ctx.popFunc()
+	}()
+	return b.call(ctx, this, args)
 }
 
 // =========
@@ -295,7 +430,23 @@ func (ctx *Context) binary(op string, left, right Value) Value {
 	if op == "==" && left == right {
 		return TRUE
 	}
-	return ctx.call_method(left, op, []Value{right})
+	var whence Value
+	fn := ctx.getSlot(left, op, &whence)
+	return ctx.callBinary(whence, fn, left, right)
+}
+
+// callBinary calls the operator fn, found on left. Operators on builtin
+// types (e.g. Number.+) are called directly if they get the types they
+// expect, skipping the call stack.
+func (ctx *Context) callBinary(whence, fn, left, right Value) Value {
+	if isError(fn) {
+		return fn
+	}
+	if b, ok := fn.(*Builtin); ok && b.op != nil && b.this == nil &&
+		left.Type() == b.ltype && (b.rtype == VT_ANY || right.Type() == b.rtype) {
+		return b.op(ctx, left, right)
+	}
+	return ctx.call(whence, fn, left, []Value{right})
 }
 
 // areObjectsEqual is a shortcut for binary(==, ...)
@@ -309,6 +460,12 @@ func (ctx *Context) unary(op lexer.TokenType, right Value) Value {
 		return Boolean(!isTruthy(right))
 	case op == lexer.MINUS && right.Type() == VT_NUMBER:
 		return Number(-right.(Number))
+	case op == lexer.TILDE && right.Type() == VT_NUMBER:
+		n, ok := toInt64(right.(Number))
+		if !ok {
+			return newError(ctx, String("bitwise operands must be integers"))
+		}
+		return Number(^n)
 	}
 	return newError(ctx, String(fmt.Sprintf(
 		"unsupported operand for %q: %s",
@@ -0,0 +1,229 @@
+package eval
+
+// ======
+// Shapes
+// ======
+//
+// Objects do not keep their slots in a map of their own. Instead, each
+// object has a shape which maps slot names to indices into the object's
+// values. Adding a slot moves the object to the next shape in a tree of
+// transitions, so objects with the same prototype which had the same slots
+// added in the same order (e.g. by the same init method) share a shape.
+// This lets call sites cache where a slot was found (see inlineCache), and
+// cheaply check whether the cached entry applies to a receiver.
+//
+// Prototypes are different: they are usually unique, and have many slots
+// added to them over time. They get an `owned' shape instead, which is
+// replaced (but not copied) whenever a slot is added.
+
+type shape struct {
+	names       []string // in the order they were added.
+	index       map[string]int
+	transitions map[string]*shape // nil for owned shapes.
+}
+
+func newShape() *shape {
+	return &shape{
+		index:       map[string]int{},
+		transitions: map[string]*shape{},
+	}
+}
+
+// with returns the shape of an object with this shape, after adding the
+// slot name.
+func (s *shape) with(name string) *shape {
+	if s.transitions == nil {
+		// owned: the old shape will never be used again, so we can
+		// take over its names and index.
+		s.index[name] = len(s.names)
+		return &shape{names: append(s.names, name), index: s.index}
+	}
+	if next, ok := s.transitions[name]; ok {
+		return next
+	}
+	next := &shape{
+		names:       make([]string, len(s.names), len(s.names)+1),
+		index:       make(map[string]int, len(s.index)+1),
+		transitions: map[string]*shape{},
+	}
+	copy(next.names, s.names)
+	for k, v := range s.index {
+		next.index[k] = v
+	}
+	next.index[name] = len(s.names)
+	next.names = append(next.names, name)
+	s.transitions[name] = next
+	return next
+}
+
+// owned returns an owned copy of s.
+func (s *shape) owned() *shape {
+	o := &shape{
+		names: append([]string{}, s.names...),
+		index: make(map[string]int, len(s.index)),
+	}
+	for k, v := range s.index {
+		o.index[k] = v
+	}
+	return o
+}
+
+// slotStore holds the slots of an Object, Function or Builtin. The zero
+// value is an empty store.
+type slotStore struct {
+	shape  *shape // nil if there are no slots.
+	values []Value
+	// isProto is set once the object is used as a prototype, and root is
+	// the initial shape of objects having it as their prototype.
+	isProto bool
+	root    *shape
+}
+
+// get returns the slot with the given name, or nil if there is none.
+func (s *slotStore) get(name string) Value {
+	if s.shape != nil {
+		if i, ok := s.shape.index[name]; ok {
+			return s.values[i]
+		}
+	}
+	return nil
+}
+
+// set sets the slot, returning whether it was added.
+func (s *slotStore) set(name string, v Value) bool {
+	if s.shape == nil {
+		s.shape = &shape{index: map[string]int{}}
+		if !s.isProto {
+			s.shape.transitions = map[string]*shape{}
+		}
+	} else if i, ok := s.shape.index[name]; ok {
+		s.values[i] = v
+		return false
+	}
+	s.shape = s.shape.with(name)
+	s.values = append(s.values, v)
+	return true
+}
+
+// names returns the slot names, in the order they were added.
+func (s *slotStore) names() []string {
+	if s.shape == nil {
+		return nil
+	}
+	return s.shape.names
+}
+
+// markProto records that the object is used as a prototype, returning
+// the initial shape of its children.
+func (s *slotStore) markProto() *shape {
+	if !s.isProto {
+		s.isProto = true
+		s.root = newShape()
+		if s.shape != nil {
+			s.shape = s.shape.owned()
+		}
+	}
+	return s.root
+}
+
+// =============
+// Inline Caches
+// =============
+//
+// Slot lookups from Get and Method nodes (and the VM's OP_GET_SLOT and
+// OP_GET_METHOD) go through an inline cache, which remembers where the
+// slot was found for the last few receivers seen at that call site.
+//
+// An entry matches a receiver if both its shape and its prototype are
+// the same. If the slot was the receiver's own, then the shape tells
+// us where it is. Otherwise, the slot lives on some prototype, and the
+// entry is only valid while no slots have been added to any prototype
+// since -- Context.protoEpoch counts those additions.
+
+const inlineCacheSize = 4
+
+type cacheEntry struct {
+	shape  *shape
+	proto  Value
+	epoch  uint64
+	holder Value // nil if the slot is the receiver's own.
+	store  *slotStore
+	index  int
+}
+
+type inlineCache struct {
+	entries [inlineCacheSize]cacheEntry
+	n       int
+}
+
+// nodeCache returns the inline cache kept in a node's Cache field,
+// creating it on first use.
+func nodeCache(cache *interface{}) *inlineCache {
+	ic, ok := (*cache).(*inlineCache)
+	if !ok {
+		ic = &inlineCache{}
+		*cache = ic
+	}
+	return ic
+}
+
+func slotsOf(obj Value) *slotStore {
+	if obj_slots, ok := obj.(hasSlots); ok {
+		return obj_slots.getSlots()
+	}
+	return nil
+}
+
+// cachedGetSlot is like getSlot, but uses and updates the cache ic.
+func (ctx *Context) cachedGetSlot(ic *inlineCache, obj Value, name string, whence *Value) Value {
+	store := slotsOf(obj)
+	var sh *shape
+	if store != nil {
+		sh = store.shape
+	}
+	proto := ctx.getPrototype(obj)
+	for i := 0; i < ic.n; i++ {
+		e := &ic.entries[i]
+		if e.shape != sh || e.proto != proto {
+			continue
+		}
+		if e.holder == nil {
+			if whence != nil {
+				*whence = obj
+			}
+			return store.values[e.index]
+		}
+		if e.epoch == ctx.protoEpoch {
+			if whence != nil {
+				*whence = e.holder
+			}
+			return e.store.values[e.index]
+		}
+		// stale: refresh this entry below.
+		ic.n--
+		ic.entries[i] = ic.entries[ic.n]
+		break
+	}
+	// slow path: walk the prototype chain.
+	holder := obj
+	for holder != nil {
+		if s := slotsOf(holder); s != nil && s.shape != nil {
+			if i, ok := s.shape.index[name]; ok {
+				if ic.n < inlineCacheSize {
+					e := cacheEntry{shape: sh, proto: proto, epoch: ctx.protoEpoch, store: s, index: i}
+					if holder != obj {
+						e.holder = holder
+					}
+					ic.entries[ic.n] = e
+					ic.n++
+				}
+				if whence != nil {
+					*whence = holder
+				}
+				return s.values[i]
+			}
+		}
+		holder = ctx.getPrototype(holder)
+	}
+	return ctx.noSlotError(obj, name)
+}
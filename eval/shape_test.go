@@ -0,0 +1,86 @@
+package eval
+
+import "testing"
+
+func TestShapesShared(t *testing.T) {
+	ctx := NewContext()
+	proto := newObject(ctx.globals.Object)
+	a := newObject(proto)
+	b := newObject(proto)
+	for _, obj := range []*Object{a, b} {
+		ctx.setSlot(obj, "x", Number(1))
+		ctx.setSlot(obj, "y", Number(2))
+	}
+	if a.slots.shape != b.slots.shape {
+		t.Errorf("expected objects built the same way to share a shape")
+	}
+	c := newObject(proto)
+	ctx.setSlot(c, "y", Number(2))
+	ctx.setSlot(c, "x", Number(1))
+	if a.slots.shape == c.slots.shape {
+		t.Errorf("expected slots added in a different order to give a different shape")
+	}
+	// updating a slot keeps the shape.
+	shape := a.slots.shape
+	ctx.setSlot(a, "x", Number(3))
+	if a.slots.shape != shape || a.slots.get("x") != Number(3) {
+		t.Errorf("expected x to be updated in place")
+	}
+	if names := c.slots.names(); len(names) != 2 || names[0] != "y" || names[1] != "x" {
+		t.Errorf("expected names in insertion order, got=%v", names)
+	}
+}
+
+func TestInlineCache(t *testing.T) {
+	ctx := NewContext()
+	proto := newObject(ctx.globals.Object)
+	ctx.setSlot(proto, "f", Number(1))
+	child := newObject(proto)
+	obj := newObject(child)
+	ic := &inlineCache{}
+	get := func() Value {
+		var whence Value
+		rv := ctx.cachedGetSlot(ic, obj, "f", &whence)
+		if isError(rv) {
+			t.Fatalf("unexpected error: %s", rv.(*Error).String())
+		}
+		return rv
+	}
+	if rv := get(); rv != Number(1) || ic.n != 1 {
+		t.Fatalf("expected f=1 and a cache entry, got=%#v (n=%d)", rv, ic.n)
+	}
+	// value updates are seen without invalidating.
+	epoch := ctx.protoEpoch
+	ctx.setSlot(proto, "f", Number(2))
+	if rv := get(); rv != Number(2) || ctx.protoEpoch != epoch {
+		t.Errorf("expected f=2 with the same epoch, got=%#v", rv)
+	}
+	// shadowing on the prototype chain invalidates.
+	ctx.setSlot(child, "f", Number(3))
+	if ctx.protoEpoch == epoch {
+		t.Errorf("expected adding a slot to a prototype to bump the epoch")
+	}
+	if rv := get(); rv != Number(3) {
+		t.Errorf("expected f=3, got=%#v", rv)
+	}
+	// as does shadowing on the receiver.
+	epoch = ctx.protoEpoch
+	ctx.setSlot(obj, "f", Number(4))
+	if ctx.protoEpoch != epoch {
+		t.Errorf("expected adding a slot to a non-prototype to keep the epoch")
+	}
+	if rv := get(); rv != Number(4) {
+		t.Errorf("expected f=4, got=%#v", rv)
+	}
+	// the cache is bounded.
+	for i := 0; i < 2*inlineCacheSize; i++ {
+		o := newObject(proto)
+		ctx.setSlot(o, "slot"+string(rune('a'+i)), NIL)
+		if rv := ctx.cachedGetSlot(ic, o, "f", nil); rv != Number(2) {
+			t.Errorf("expected f=2, got=%#v", rv)
+		}
+	}
+	if ic.n != inlineCacheSize {
+		t.Errorf("expected %d cache entries, got=%d", inlineCacheSize, ic.n)
+	}
+}
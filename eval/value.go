@@ -3,6 +3,8 @@ package eval
 import (
 	"bytes"
 	"fmt"
+	"strings"
+	"toe/diag"
 	"toe/parser"
 )
 
@@ -22,6 +24,7 @@ const (
 	VT_ARRAY
 	VT_HASH
 	VT_BUILTIN
+	VT_ITERATOR
 	// Runtime Control
 	VT_SUPER
 	VT_BREAK
@@ -54,24 +57,30 @@ type String string
 
 type Object struct {
 	proto Value
-	slots map[string]Value
+	slots slotStore
 	data  Value // special value pointing to a builtin type.
 }
 
-func newSlots() map[string]Value {
-	return map[string]Value{}
-}
-
 func newObject(proto Value) *Object {
-	return &Object{
-		proto: proto,
-		slots: newSlots(),
+	obj := &Object{proto: proto}
+	if store := slotsOf(proto); store != nil {
+		obj.slots.shape = store.markProto()
 	}
+	return obj
+}
+
+// newProto returns a new object which is marked as a prototype from the
+// start, as it is the prototype of builtin values.
+func newProto(proto Value) *Object {
+	obj := newObject(proto)
+	obj.slots.markProto()
+	return obj
 }
 
 type Function struct {
-	slots    map[string]Value
+	slots    slotStore
 	node     *parser.Function
+	code     *compiledCode // if compiled, see vm.go
 	closure  *environment
 	filename string
 	this     Value
@@ -79,7 +88,6 @@ type Function struct {
 
 func newFunction(filename string, node *parser.Function, env *environment) *Function {
 	return &Function{
-		slots:    newSlots(),
 		filename: filename,
 		node:     node,
 		closure:  env,
@@ -106,21 +114,24 @@ func newHash(ctx *Context) *Object {
 	return obj
 }
 
-type builtinFunc func(ctx *Context, this Value, args []Value) Value
+type BuiltinFunc func(ctx *Context, this Value, args []Value) Value
 
 // Builtin represents a built-in function
 type Builtin struct {
-	slots map[string]Value
+	slots slotStore
 	name  string
 	this  Value
-	call  builtinFunc
+	call  BuiltinFunc
+	// operators on builtin types are called directly when the operands
+	// have these types, see binOp2Builtin.
+	op           binOpFunc
+	ltype, rtype ValueType
 }
 
-func newBuiltin(name string, call builtinFunc) *Builtin {
+func newBuiltin(name string, call BuiltinFunc) *Builtin {
 	return &Builtin{
-		slots: newSlots(),
-		name:  name,
-		call:  call,
+		name: name,
+		call: call,
 	}
 }
 
@@ -156,30 +167,106 @@ type Break struct{}
 type Continue struct{}
 type Return struct{ value Value }
 
-type context struct {
-	fn  string // filename
-	ln  int    // line no
-	col int    // col
-	ctx string // e.g. [Module] or [Function ...]
+type stackFrame struct {
+	fn     string // filename
+	ln     int    // line no
+	col    int    // col
+	endLn  int    // end of the token, just after it
+	endCol int
+	ctx    string // e.g. [Module] or [Function ...]
+}
+
+func (c stackFrame) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", c.fn, c.ln, c.col, c.ctx)
+}
+
+func (c stackFrame) diagnostic() diag.Diagnostic {
+	length := 1
+	if c.endLn == c.ln && c.endCol > c.col {
+		length = c.endCol - c.col
+	}
+	return diag.Diagnostic{Filename: c.fn, Line: c.ln, Column: c.col, Length: length, Kind: "runtime"}
 }
 
 type Error struct {
 	ctx    *Context
 	reason Value
-	stack  []context
+	stack  []stackFrame
+	abort  error // see Aborted.
 }
 
 func newError(ctx *Context, reason Value) *Error {
 	return &Error{
 		ctx:    ctx,
 		reason: reason,
-		stack:  []context{},
+		stack:  []stackFrame{},
 	}
 }
 
+// newAbort returns an error which aborts evaluation because of cause.
+func newAbort(ctx *Context, cause error) *Error {
+	err := newError(ctx, String("execution aborted: "+cause.Error()))
+	err.abort = cause
+	return err
+}
+
+// Aborted returns why evaluation was aborted -- either ErrStepLimit, or
+// the error of the context.Context given to SetContext. It returns nil for
+// ordinary errors.
+func (e *Error) Aborted() error {
+	return e.abort
+}
+
+// Reason returns the thrown value.
+func (e *Error) Reason() Value {
+	return e.reason
+}
+
+// Error implements the error interface, returning the same as String.
+func (e *Error) Error() string {
+	return e.String()
+}
+
 func (e *Error) String() string {
+	return e.Render(nil)
+}
+
+// Render is like String, but shows the source line (if it is in sources)
+// below each frame of the stack.
+func (e *Error) Render(sources diag.Sources) string {
 	var buf bytes.Buffer
-	reason := e.ctx.call_method(e.reason, "inspect", nil)
+	buf.WriteString("Error: ")
+	buf.WriteString(e.inspectReason())
+	buf.WriteString("\n")
+	lines := []string{}
+	addFrames := func(frames []stackFrame) {
+		for _, frame := range frames {
+			line := "  at " + frame.String()
+			if snippet := sources.Snippet(frame.diagnostic(), "    "); snippet != "" {
+				line += "\n" + snippet
+			}
+			lines = append(lines, line)
+		}
+	}
+	head, tail, omitted := e.frames()
+	addFrames(head)
+	if omitted > 0 {
+		lines = append(lines, fmt.Sprintf("  ... %d more frames ...", omitted))
+	}
+	addFrames(tail)
+	buf.WriteString(strings.Join(lines, "\n"))
+	return buf.String()
+}
+
+// inspectReason returns the inspected reason.
+func (e *Error) inspectReason() string {
+	var reason Value
+	if e.abort != nil {
+		// the context may still be aborting, so don't call inspect().
+		reason = String(fmt.Sprintf("%q", string(e.reason.(String))))
+	} else {
+		reason = e.ctx.call_method(e.reason, "inspect", nil)
+	}
 	if isError(reason) {
 		reason = String("<error inspect() failed>")
 	}
@@ -187,16 +274,70 @@ func (e *Error) String() string {
 	if str == nil {
 		str = String("<error inspect() failed>")
 	}
-	buf.WriteString("Error: ")
-	buf.WriteString(string(str.(String)))
-	buf.WriteString("\n")
-	for i, ctx := range e.stack {
-		buf.WriteString(fmt.Sprintf("  at %s:%d:%d: %s", ctx.fn, ctx.ln, ctx.col, ctx.ctx))
-		if i != len(e.stack)-1 {
-			buf.WriteString("\n")
-		}
+	return string(str.(String))
+}
+
+// Report returns the error in machine-readable form. It refers to the
+// innermost frame, and lists the whole stack. The message is the reason
+// if it is a string, and the inspected reason otherwise.
+func (e *Error) Report() diag.Report {
+	msg := ""
+	if s, ok := e.reason.(String); ok {
+		msg = string(s)
+	} else {
+		msg = e.inspectReason()
 	}
-	return buf.String()
+	r := diag.Report{Severity: "error", Code: diag.CodeRuntime, Kind: "runtime", Message: msg}
+	if len(e.stack) > 0 {
+		d := e.stack[0].diagnostic()
+		d.Message = msg
+		r = d.Report()
+	}
+	if e.abort != nil {
+		r.Code = diag.CodeAbort
+	}
+	for _, frame := range e.stack {
+		r.Frames = append(r.Frames, diag.Frame{
+			File:   frame.fn,
+			Line:   frame.ln,
+			Column: frame.col,
+			Name:   frame.ctx,
+		})
+	}
+	return r
+}
+
+// maxTraceFrames is the number of frames shown at each end of a long
+// stack trace (e.g. from runaway recursion).
+const maxTraceFrames = 10
+
+// frames returns the error's stack, innermost frame first. If the stack
+// is long, then only the frames at either end are returned, and omitted
+// is the number of frames left out in between.
+func (e *Error) frames() (head, tail []stackFrame, omitted int) {
+	n := len(e.stack)
+	if n <= 2*maxTraceFrames+1 {
+		return e.stack, nil, 0
+	}
+	return e.stack[:maxTraceFrames], e.stack[n-maxTraceFrames:], n - 2*maxTraceFrames
+}
+
+// trace returns the error's stack as strings, innermost frame first.
+// If the stack is long, then the omitted frames in the middle are replaced
+// by a single line.
+func (e *Error) trace() []string {
+	head, tail, omitted := e.frames()
+	trace := []string{}
+	for _, frame := range head {
+		trace = append(trace, frame.String())
+	}
+	if omitted > 0 {
+		trace = append(trace, fmt.Sprintf("... %d more frames ...", omitted))
+	}
+	for _, frame := range tail {
+		trace = append(trace, frame.String())
+	}
+	return trace
 }
 
 func (v Super) Type() ValueType    { return VT_SUPER }
@@ -17,19 +17,20 @@ func _() {
 	_ = x[VT_ARRAY-7]
 	_ = x[VT_HASH-8]
 	_ = x[VT_BUILTIN-9]
-	_ = x[VT_SUPER-10]
-	_ = x[VT_BREAK-11]
-	_ = x[VT_CONTINUE-12]
-	_ = x[VT_RETURN-13]
-	_ = x[VT_ERROR-14]
-	_ = x[VT_TOMBSTONE-15]
-	_ = x[VT_ANY-16]
-	_ = x[VT_CALL-17]
+	_ = x[VT_ITERATOR-10]
+	_ = x[VT_SUPER-11]
+	_ = x[VT_BREAK-12]
+	_ = x[VT_CONTINUE-13]
+	_ = x[VT_RETURN-14]
+	_ = x[VT_ERROR-15]
+	_ = x[VT_TOMBSTONE-16]
+	_ = x[VT_ANY-17]
+	_ = x[VT_CALL-18]
 }
 
-const _ValueType_name = "VT_NILVT_BOOLEANVT_NUMBERVT_STRINGVT_FUNCTIONVT_OBJECTVT_ARRAYVT_HASHVT_BUILTINVT_SUPERVT_BREAKVT_CONTINUEVT_RETURNVT_ERRORVT_TOMBSTONEVT_ANYVT_CALL"
+const _ValueType_name = "VT_NILVT_BOOLEANVT_NUMBERVT_STRINGVT_FUNCTIONVT_OBJECTVT_ARRAYVT_HASHVT_BUILTINVT_ITERATORVT_SUPERVT_BREAKVT_CONTINUEVT_RETURNVT_ERRORVT_TOMBSTONEVT_ANYVT_CALL"
 
-var _ValueType_index = [...]uint8{0, 6, 16, 25, 34, 45, 54, 62, 69, 79, 87, 95, 106, 115, 123, 135, 141, 148}
+var _ValueType_index = [...]uint8{0, 6, 16, 25, 34, 45, 54, 62, 69, 79, 90, 98, 106, 117, 126, 134, 146, 152, 159}
 
 func (i ValueType) String() string {
 	i -= 1
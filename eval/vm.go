@@ -0,0 +1,355 @@
+package eval
+
+import (
+	"fmt"
+	"toe/compiler"
+	"toe/lexer"
+)
+
+// ==
+// VM
+// ==
+//
+// The VM runs code produced by package compiler. It shares everything
+// but the dispatch loop with the evaluator: values, environments, the
+// call stack and error stacks all work the same way. Each call to a
+// compiled function gets its own Go call to runCode, so builtins can
+// call back into compiled code like any other function.
+
+// compiledCode is a compiler.Code with its constants converted to values.
+type compiledCode struct {
+	code   *compiler.Code
+	consts []Value
+	funcs  []*compiledCode
+	caches []inlineCache
+}
+
+func newCompiledCode(code *compiler.Code) *compiledCode {
+	cc := &compiledCode{
+		code:   code,
+		consts: make([]Value, len(code.Consts)),
+		funcs:  make([]*compiledCode, len(code.Funcs)),
+		caches: make([]inlineCache, code.Caches),
+	}
+	for i, c := range code.Consts {
+		switch c := c.(type) {
+		case float64:
+			cc.consts[i] = Number(c)
+		case string:
+			cc.consts[i] = String(c)
+		default:
+			panic(fmt.Sprintf("unhandled constant %#+v", c))
+		}
+	}
+	for i, f := range code.Funcs {
+		cc.funcs[i] = newCompiledCode(f)
+	}
+	return cc
+}
+
+// callArgs returns the arguments on the stack for calling fn. Functions
+// copy their arguments into their environment, so they can be given the
+// stack itself; builtins get a copy, since they may keep the slice.
+func callArgs(fn Value, stack []Value) []Value {
+	if _, ok := fn.(*Function); ok {
+		return stack
+	}
+	args := make([]Value, len(stack))
+	copy(args, stack)
+	return args
+}
+
+// handler is an active OP_SETUP_TRY.
+type handler struct {
+	target int
+	height int
+	env    *environment
+}
+
+// runCode runs cc in the current environment, returning the value of
+// OP_RETURN or an uncaught *Error.
+func (ctx *Context) runCode(cc *compiledCode) Value {
+	ops := cc.code.Ops
+	names := cc.code.Names
+	stack := make([]Value, 0, 8)
+	var handlers []handler
+	env := ctx.env
+	pc := 0
+	for {
+		start := pc
+		op := compiler.Opcode(ops[pc])
+		pc++
+		// rv is an error raised by the instruction. Errors which are
+		// being rethrown do not get another entry in their stack.
+		var rv Value
+		rethrow := false
+		switch op {
+		case compiler.OP_CONST:
+			stack = append(stack, cc.consts[compiler.ReadOperand(ops, pc)])
+			pc += 2
+		case compiler.OP_NIL:
+			stack = append(stack, NIL)
+		case compiler.OP_TRUE:
+			stack = append(stack, TRUE)
+		case compiler.OP_FALSE:
+			stack = append(stack, FALSE)
+		case compiler.OP_POP:
+			stack = stack[:len(stack)-1]
+		case compiler.OP_GET_VAR:
+			depth := compiler.ReadOperand(ops, pc)
+			slot := compiler.ReadOperand(ops, pc+2)
+			name := compiler.ReadOperand(ops, pc+4)
+			pc += 6
+			value := ctx.env.ancestor(depth).slots[slot]
+			if value == nil {
+				rv = newError(ctx, String(fmt.Sprintf("%q is not defined", names[name])))
+				break
+			}
+			stack = append(stack, value)
+		case compiler.OP_SET_VAR:
+			depth := compiler.ReadOperand(ops, pc)
+			ctx.env.ancestor(depth).slots[compiler.ReadOperand(ops, pc+2)] = stack[len(stack)-1]
+			pc += 4
+		case compiler.OP_DEFINE:
+			ctx.env.slots[compiler.ReadOperand(ops, pc)] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			pc += 2
+		case compiler.OP_GET_NAME:
+			depth := compiler.ReadOperand(ops, pc)
+			name := names[compiler.ReadOperand(ops, pc+2)]
+			pc += 4
+			value, ok := ctx.env.ancestor(depth).get(name)
+			if !ok {
+				rv = newError(ctx, String(fmt.Sprintf("%q is not defined", name)))
+				break
+			}
+			stack = append(stack, value)
+		case compiler.OP_SET_NAME:
+			depth := compiler.ReadOperand(ops, pc)
+			name := names[compiler.ReadOperand(ops, pc+2)]
+			pc += 4
+			ctx.env.ancestor(depth).set(name, stack[len(stack)-1])
+		case compiler.OP_DEFINE_NAME:
+			ctx.env.set(names[compiler.ReadOperand(ops, pc)], stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+			pc += 2
+		case compiler.OP_PUSH_ENV:
+			ctx.pushEnv(compiler.ReadOperand(ops, pc))
+			pc += 2
+		case compiler.OP_POP_ENV:
+			ctx.popEnv()
+		case compiler.OP_BINARY:
+			name := names[compiler.ReadOperand(ops, pc)]
+			ic := &cc.caches[compiler.ReadOperand(ops, pc+2)]
+			pc += 4
+			n := len(stack)
+			left, right := stack[n-2], stack[n-1]
+			if name == "==" && left == right {
+				rv = TRUE
+			} else {
+				var whence Value
+				fn := ctx.cachedGetSlot(ic, left, name, &whence)
+				rv = ctx.callBinary(whence, fn, left, right)
+			}
+			stack = stack[:n-1]
+			stack[n-2] = rv
+		case compiler.OP_UNARY:
+			typ := lexer.TokenType(compiler.ReadOperand(ops, pc))
+			pc += 2
+			rv = ctx.unary(typ, stack[len(stack)-1])
+			stack[len(stack)-1] = rv
+		case compiler.OP_JUMP:
+			pc = compiler.ReadOperand(ops, pc)
+			if pc < start {
+				// jumping backwards, i.e. looping.
+				if err := ctx.step(); err != nil {
+					rv = err
+				}
+			}
+		case compiler.OP_JUMP_FALSE:
+			cond := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if isTruthy(cond) {
+				pc += 2
+			} else {
+				pc = compiler.ReadOperand(ops, pc)
+			}
+		case compiler.OP_AND, compiler.OP_OR:
+			if isTruthy(stack[len(stack)-1]) == (op == compiler.OP_OR) {
+				pc = compiler.ReadOperand(ops, pc)
+			} else {
+				stack = stack[:len(stack)-1]
+				pc += 2
+			}
+		case compiler.OP_GET_SLOT:
+			name := names[compiler.ReadOperand(ops, pc)]
+			ic := &cc.caches[compiler.ReadOperand(ops, pc+2)]
+			pc += 4
+			object := stack[len(stack)-1]
+			if isSuper(object) {
+				object = object.(Super).proto
+			}
+			rv = ctx.cachedGetSlot(ic, object, name, nil)
+			stack[len(stack)-1] = rv
+		case compiler.OP_SET_SLOT:
+			name := names[compiler.ReadOperand(ops, pc)]
+			pc += 2
+			n := len(stack)
+			object := stack[n-1]
+			if isSuper(object) {
+				object = object.(Super).proto
+			}
+			rv = ctx.setSlot(object, name, stack[n-2])
+			stack = stack[:n-1]
+		case compiler.OP_INDEX:
+			n := len(stack)
+			rv = ctx.call_method(stack[n-2], "get", []Value{stack[n-1]})
+			stack = stack[:n-1]
+			stack[n-2] = rv
+		case compiler.OP_SET_INDEX:
+			n := len(stack)
+			rv = ctx.call_method(stack[n-2], "set", []Value{stack[n-1], stack[n-3]})
+			stack = stack[:n-2]
+		case compiler.OP_GET_METHOD:
+			name := names[compiler.ReadOperand(ops, pc)]
+			ic := &cc.caches[compiler.ReadOperand(ops, pc+2)]
+			pc += 4
+			object := stack[len(stack)-1]
+			this := object
+			var whence Value
+			if isSuper(object) {
+				object = object.(Super).proto
+				this = ctx.this
+			}
+			rv = ctx.cachedGetSlot(ic, object, name, &whence)
+			stack[len(stack)-1] = this
+			stack = append(stack, rv, whence)
+		case compiler.OP_CALL_METHOD:
+			argc := compiler.ReadOperand(ops, pc)
+			pc += 2
+			base := len(stack) - argc - 3
+			rv = ctx.call(stack[base+2], stack[base+1], stack[base], callArgs(stack[base+1], stack[base+3:]))
+			stack = stack[:base+1]
+			stack[base] = rv
+		case compiler.OP_CALL:
+			argc := compiler.ReadOperand(ops, pc)
+			pc += 2
+			base := len(stack) - argc - 1
+			rv = ctx.call(nil, stack[base], NIL, callArgs(stack[base], stack[base+1:]))
+			stack = stack[:base+1]
+			stack[base] = rv
+		case compiler.OP_ARRAY:
+			n := compiler.ReadOperand(ops, pc)
+			pc += 2
+			values := make([]Value, n)
+			copy(values, stack[len(stack)-n:])
+			stack = append(stack[:len(stack)-n], newArray(ctx, values))
+		case compiler.OP_HASH:
+			stack = append(stack, newHash(ctx))
+		case compiler.OP_HASH_INSERT:
+			n := len(stack)
+			hash := stack[n-3].(*Object).data.(*Hash)
+			if err := hash.table.insert(stack[n-2], stack[n-1]); err != nil {
+				rv = err
+			}
+			stack = stack[:n-2]
+		case compiler.OP_CLOSURE:
+			f := cc.funcs[compiler.ReadOperand(ops, pc)]
+			pc += 2
+			fn := newFunction(ctx.stack[len(ctx.stack)-1].Filename(), f.code.Function, ctx.env)
+			fn.code = f
+			stack = append(stack, fn)
+		case compiler.OP_SUPER:
+			proto := ctx.getPrototype(ctx.whence)
+			if proto == nil {
+				rv = newError(ctx, String("object has nil prototype"))
+				break
+			}
+			stack = append(stack, Super{proto})
+		case compiler.OP_RETURN:
+			return stack[len(stack)-1]
+		case compiler.OP_ITER:
+			iterator, err := ctx.getIterator(stack[len(stack)-1])
+			if err != nil {
+				rv = err
+				break
+			}
+			stack[len(stack)-1] = iterator
+		case compiler.OP_FOR_NEXT:
+			iterator := stack[len(stack)-1].(Iterator)
+			done := iterator.Done()
+			if isError(done) {
+				rv = done
+				break
+			}
+			if isTruthy(done) {
+				pc = compiler.ReadOperand(ops, pc)
+				break
+			}
+			next := iterator.Next()
+			if isError(next) {
+				rv = next
+				break
+			}
+			ctx.env.slots[0] = next
+			pc += 2
+		case compiler.OP_CLOSE_ITER:
+			iterator := stack[len(stack)-1].(Iterator)
+			stack = stack[:len(stack)-1]
+			if v := iterator.Close(); isError(v) {
+				rv = v
+			}
+		case compiler.OP_CLOSE_AT:
+			iterator := stack[compiler.ReadOperand(ops, pc)].(Iterator)
+			pc += 2
+			if v := iterator.Close(); isError(v) {
+				rv = v
+			}
+		case compiler.OP_CLOSE_QUIET:
+			n := len(stack)
+			stack[n-2].(Iterator).Close()
+			stack[n-2] = stack[n-1]
+			stack = stack[:n-1]
+		case compiler.OP_SETUP_TRY:
+			handlers = append(handlers, handler{
+				target: compiler.ReadOperand(ops, pc),
+				height: len(stack),
+				env:    ctx.env,
+			})
+			pc += 2
+		case compiler.OP_POP_TRY:
+			handlers = handlers[:len(handlers)-1]
+		case compiler.OP_CAUGHT:
+			stack[len(stack)-1] = ctx.caughtValue(stack[len(stack)-1].(*Error))
+		case compiler.OP_RETHROW:
+			rv = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			rethrow = true
+		default:
+			panic(fmt.Sprintf("unhandled opcode %s", op))
+		}
+		if rv == nil || !isError(rv) {
+			continue
+		}
+		err := rv.(*Error)
+		if !rethrow {
+			if pos, ok := cc.code.Position(start); ok {
+				ctx.addErrorStack(err, lexer.Token{
+					Line:      pos.Line,
+					Column:    pos.Column,
+					EndLine:   pos.EndLine,
+					EndColumn: pos.EndColumn,
+				})
+			}
+		}
+		if len(handlers) == 0 || err.abort != nil {
+			ctx.env = env
+			return err
+		}
+		h := handlers[len(handlers)-1]
+		handlers = handlers[:len(handlers)-1]
+		stack = append(stack[:h.height], err)
+		ctx.env = h.env
+		pc = h.target
+	}
+}
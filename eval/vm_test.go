@@ -0,0 +1,77 @@
+package eval
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestVMInterop(t *testing.T) {
+	// compiled and interpreted code can call each other, and share
+	// closed-over variables.
+	dir := writeModules(t, map[string]string{
+		"walker.toe": `
+let count = 0;
+exports.incr = fn() { count = count + 1; return count; };
+exports.apply = fn(f, x) { return f(x); };
+`,
+		"main.toe": `
+let walker = require("walker.toe");
+walker.incr();
+exports.count = walker.incr();
+exports.applied = walker.apply(fn(x) { return x * 2; }, 21);
+`,
+	})
+	ctx := NewContext()
+	if rv := ctx.requireModule("", filepath.Join(dir, "walker.toe")); isError(rv) {
+		t.Fatalf("unexpected error: %s", rv.(*Error).String())
+	}
+	ctx.UseCompiler(true)
+	rv := ctx.requireModule("", filepath.Join(dir, "main.toe"))
+	if isError(rv) {
+		t.Fatalf("unexpected error: %s", rv.(*Error).String())
+	}
+	exports := rv.(*Object)
+	if exports.slots.get("count") != Number(2) {
+		t.Errorf("expected count=2, got=%#v", exports.slots.get("count"))
+	}
+	if exports.slots.get("applied") != Number(42) {
+		t.Errorf("expected applied=42, got=%#v", exports.slots.get("applied"))
+	}
+}
+
+// ----------
+// Benchmarks
+// ----------
+
+const benchmarkSource = `
+let fib = fn(n) {
+	if (n < 2) {
+		return n;
+	}
+	return fib(n - 1) + fib(n - 2);
+};
+let total = 0;
+let i = 0;
+while (i < 2000) {
+	total = total + i % 7;
+	i = i + 1;
+}
+fib(18);
+`
+
+func benchmarkModule(b *testing.B, compile bool) {
+	for n := 0; n < b.N; n++ {
+		ctx := NewContext()
+		ctx.UseCompiler(compile)
+		module, errs := ctx.parseModule("<bench>", benchmarkSource)
+		if len(errs) != 0 {
+			b.Fatal(errs)
+		}
+		if rv := ctx.EvalStmt(module); isError(rv) {
+			b.Fatal(rv.(*Error).String())
+		}
+	}
+}
+
+func BenchmarkEvaluator(b *testing.B) { benchmarkModule(b, false) }
+func BenchmarkVM(b *testing.B)        { benchmarkModule(b, true) }
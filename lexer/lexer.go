@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"toe/diag"
 	"unicode/utf8"
 )
 
@@ -28,6 +29,10 @@ const (
 	SEMICOLON
 	SLASH
 	STAR
+	PERCENT
+	AMPERSAND
+	PIPE
+	CARET
 	// one or two-character tokens
 	BANG
 	BANG_EQUAL
@@ -35,8 +40,13 @@ const (
 	EQUAL_EQUAL
 	GREATER
 	GREATER_EQUAL
+	GREATER_GREATER
 	LESS
 	LESS_EQUAL
+	LESS_LESS
+	STAR_STAR
+	TILDE
+	TILDE_SLASH
 	// literals
 	IDENTIFIER
 	STRING
@@ -57,6 +67,9 @@ const (
 	WHILE
 	BREAK
 	CONTINUE
+	TRY
+	CATCH
+	FINALLY
 	// meta
 	EOF
 )
@@ -77,6 +90,9 @@ var keywords = map[string]TokenType{
 	"while":    WHILE,
 	"break":    BREAK,
 	"continue": CONTINUE,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
 }
 
 type Token struct {
@@ -88,6 +104,38 @@ type Token struct {
 	Literal interface{} // simple literals (number, str, bool, nil)
 	Line    int
 	Column  int
+	// Offset is the byte offset of the lexeme in the source, and
+	// EndLine and EndColumn are the position just after it.
+	Offset    int
+	EndLine   int
+	EndColumn int
+}
+
+// Position is a position in the source.
+type Position struct {
+	Offset int // in bytes, starting from 0.
+	Line   int // starting from 1.
+	Column int // starting from 1, in runes.
+}
+
+// Span is a range of the source, from Start up to (but not including)
+// End.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Span returns the range of the source covered by the token.
+func (t Token) Span() Span {
+	return Span{
+		Start: Position{t.Offset, t.Line, t.Column},
+		End:   Position{t.Offset + len(t.Lexeme), t.EndLine, t.EndColumn},
+	}
+}
+
+// To returns the span from the start of s to the end of t.
+func (s Span) To(t Span) Span {
+	return Span{Start: s.Start, End: t.End}
 }
 
 type Error struct {
@@ -97,9 +145,17 @@ type Error struct {
 	Message  string
 }
 
-func (e Error) Error() string { return e.String() }
-func (e Error) String() string {
-	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Message)
+func (e Error) Error() string  { return e.String() }
+func (e Error) String() string { return e.Diagnostic().String() }
+func (e Error) Diagnostic() diag.Diagnostic {
+	return diag.Diagnostic{
+		Filename: e.Filename,
+		Line:     e.Line,
+		Column:   e.Column,
+		Length:   1,
+		Kind:     "lexer",
+		Message:  e.Message,
+	}
 }
 
 type Lexer struct {
@@ -128,6 +184,13 @@ func New(filename string, source string) *Lexer {
 	}
 }
 
+// SetLine sets the number of the first line of the source, e.g. if it
+// continues earlier input.
+func (l *Lexer) SetLine(line int) {
+	l.line = line
+	l.startLn = line
+}
+
 // utils
 
 // isAtEnd lets us know if we've reached the end of the input.
@@ -187,7 +250,14 @@ func (l *Lexer) ScanTokens() {
 	for !l.stop && !l.isAtEnd() && len(l.Errors) <= 10 {
 		l.scanToken()
 	}
-	l.Tokens = append(l.Tokens, Token{EOF, "", nil, l.line, l.column})
+	l.Tokens = append(l.Tokens, Token{
+		Type:      EOF,
+		Line:      l.line,
+		Column:    l.column,
+		Offset:    l.current,
+		EndLine:   l.line,
+		EndColumn: l.column,
+	})
 }
 
 func (l *Lexer) scanToken() {
@@ -228,7 +298,25 @@ func (l *Lexer) scanToken() {
 	case '+':
 		l.emit(PLUS)
 	case '*':
-		l.emit(STAR)
+		if l.match('*') {
+			l.emit(STAR_STAR)
+		} else {
+			l.emit(STAR)
+		}
+	case '%':
+		l.emit(PERCENT)
+	case '&':
+		l.emit(AMPERSAND)
+	case '|':
+		l.emit(PIPE)
+	case '^':
+		l.emit(CARET)
+	case '~':
+		if l.match('/') {
+			l.emit(TILDE_SLASH)
+		} else {
+			l.emit(TILDE)
+		}
 	case '/':
 		if l.match('/') {
 			for l.peek() != '\n' && !l.stop && !l.isAtEnd() {
@@ -253,12 +341,16 @@ func (l *Lexer) scanToken() {
 	case '<':
 		if l.match('=') {
 			l.emit(LESS_EQUAL)
+		} else if l.match('<') {
+			l.emit(LESS_LESS)
 		} else {
 			l.emit(LESS)
 		}
 	case '>':
 		if l.match('=') {
 			l.emit(GREATER_EQUAL)
+		} else if l.match('>') {
+			l.emit(GREATER_GREATER)
 		} else {
 			l.emit(GREATER)
 		}
@@ -365,11 +457,14 @@ func (l *Lexer) ignore() {
 func (l *Lexer) emit(typ TokenType) { l.emitLiteral(typ, nil) }
 func (l *Lexer) emitLiteral(typ TokenType, lit interface{}) {
 	l.Tokens = append(l.Tokens, Token{
-		Type:    typ,
-		Lexeme:  l.source[l.start:l.current],
-		Literal: lit,
-		Line:    l.startLn,
-		Column:  l.startCol,
+		Type:      typ,
+		Lexeme:    l.source[l.start:l.current],
+		Literal:   lit,
+		Line:      l.startLn,
+		Column:    l.startCol,
+		Offset:    l.start,
+		EndLine:   l.line,
+		EndColumn: l.column,
 	})
 	l.start = l.current
 	l.startLn = l.line
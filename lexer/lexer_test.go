@@ -10,6 +10,7 @@ func TestLexer(t *testing.T) {
 let Animal = Object.clone(nil);
 let dog = PetDog.new("阿福");
 21.50 == 2.10;
+1 % 2 ~/ 3 ** 4 & 5 | 6 ^ ~7 << 8 >> 9;
 true == false == fn() { return 2 }`)
 	lex.ScanTokens()
 	if len(lex.Errors) != 0 {
@@ -24,12 +25,12 @@ true == false == fn() { return 2 }`)
 func TestLexerBad(t *testing.T) {
 	badInputs := []string{
 		"\"ab\n\" def ghi",
-		"def | holy shit",
-		"abc & adhkfsai",
+		"def $ holy shit",
+		"abc # adhkfsai",
 		"\"abraca\xc3\x28 dabra\"",
 		"\xc3\x28",
 		"abc def \xf0\x28\x8c\xbc uu \xc3\x28 omg",
-		"abc def || omg &| abrac",
+		"abc def @@ omg $| abrac",
 	}
 	for i, input := range badInputs {
 		lex := lexer.New("<test>", input)
@@ -43,3 +44,24 @@ func TestLexerBad(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenSpans(t *testing.T) {
+	lex := lexer.New("", "let s =\n  \"阿福\";")
+	lex.ScanTokens()
+	expected := []lexer.Span{
+		{Start: lexer.Position{Offset: 0, Line: 1, Column: 1}, End: lexer.Position{Offset: 3, Line: 1, Column: 4}},   // let
+		{Start: lexer.Position{Offset: 4, Line: 1, Column: 5}, End: lexer.Position{Offset: 5, Line: 1, Column: 6}},   // s
+		{Start: lexer.Position{Offset: 6, Line: 1, Column: 7}, End: lexer.Position{Offset: 7, Line: 1, Column: 8}},   // =
+		{Start: lexer.Position{Offset: 10, Line: 2, Column: 3}, End: lexer.Position{Offset: 18, Line: 2, Column: 7}}, // "阿福"
+		{Start: lexer.Position{Offset: 18, Line: 2, Column: 7}, End: lexer.Position{Offset: 19, Line: 2, Column: 8}}, // ;
+		{Start: lexer.Position{Offset: 19, Line: 2, Column: 8}, End: lexer.Position{Offset: 19, Line: 2, Column: 8}}, // EOF
+	}
+	if len(lex.Tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got=%v", len(expected), lex.Tokens)
+	}
+	for i, tok := range lex.Tokens {
+		if tok.Span() != expected[i] {
+			t.Errorf("tokens[%d] (%q): expected %+v, got=%+v", i, tok.Lexeme, expected[i], tok.Span())
+		}
+	}
+}
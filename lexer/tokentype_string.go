@@ -22,38 +22,50 @@ func _() {
 	_ = x[SEMICOLON-12]
 	_ = x[SLASH-13]
 	_ = x[STAR-14]
-	_ = x[BANG-15]
-	_ = x[BANG_EQUAL-16]
-	_ = x[EQUAL-17]
-	_ = x[EQUAL_EQUAL-18]
-	_ = x[GREATER-19]
-	_ = x[GREATER_EQUAL-20]
-	_ = x[LESS-21]
-	_ = x[LESS_EQUAL-22]
-	_ = x[IDENTIFIER-23]
-	_ = x[STRING-24]
-	_ = x[NUMBER-25]
-	_ = x[LET-26]
-	_ = x[AND-27]
-	_ = x[OR-28]
-	_ = x[ELSE-29]
-	_ = x[FALSE-30]
-	_ = x[FN-31]
-	_ = x[FOR-32]
-	_ = x[IF-33]
-	_ = x[NIL-34]
-	_ = x[RETURN-35]
-	_ = x[SUPER-36]
-	_ = x[TRUE-37]
-	_ = x[WHILE-38]
-	_ = x[BREAK-39]
-	_ = x[CONTINUE-40]
-	_ = x[EOF-41]
+	_ = x[PERCENT-15]
+	_ = x[AMPERSAND-16]
+	_ = x[PIPE-17]
+	_ = x[CARET-18]
+	_ = x[BANG-19]
+	_ = x[BANG_EQUAL-20]
+	_ = x[EQUAL-21]
+	_ = x[EQUAL_EQUAL-22]
+	_ = x[GREATER-23]
+	_ = x[GREATER_EQUAL-24]
+	_ = x[GREATER_GREATER-25]
+	_ = x[LESS-26]
+	_ = x[LESS_EQUAL-27]
+	_ = x[LESS_LESS-28]
+	_ = x[STAR_STAR-29]
+	_ = x[TILDE-30]
+	_ = x[TILDE_SLASH-31]
+	_ = x[IDENTIFIER-32]
+	_ = x[STRING-33]
+	_ = x[NUMBER-34]
+	_ = x[LET-35]
+	_ = x[AND-36]
+	_ = x[OR-37]
+	_ = x[ELSE-38]
+	_ = x[FALSE-39]
+	_ = x[FN-40]
+	_ = x[FOR-41]
+	_ = x[IF-42]
+	_ = x[NIL-43]
+	_ = x[RETURN-44]
+	_ = x[SUPER-45]
+	_ = x[TRUE-46]
+	_ = x[WHILE-47]
+	_ = x[BREAK-48]
+	_ = x[CONTINUE-49]
+	_ = x[TRY-50]
+	_ = x[CATCH-51]
+	_ = x[FINALLY-52]
+	_ = x[EOF-53]
 }
 
-const _TokenType_name = "LEFT_PARENRIGHT_PARENLEFT_BRACERIGHT_BRACELEFT_BRACKETRIGHT_BRACKETCOMMACOLONDOTPLUSMINUSSEMICOLONSLASHSTARBANGBANG_EQUALEQUALEQUAL_EQUALGREATERGREATER_EQUALLESSLESS_EQUALIDENTIFIERSTRINGNUMBERLETANDORELSEFALSEFNFORIFNILRETURNSUPERTRUEWHILEBREAKCONTINUEEOF"
+const _TokenType_name = "LEFT_PARENRIGHT_PARENLEFT_BRACERIGHT_BRACELEFT_BRACKETRIGHT_BRACKETCOMMACOLONDOTPLUSMINUSSEMICOLONSLASHSTARPERCENTAMPERSANDPIPECARETBANGBANG_EQUALEQUALEQUAL_EQUALGREATERGREATER_EQUALGREATER_GREATERLESSLESS_EQUALLESS_LESSSTAR_STARTILDETILDE_SLASHIDENTIFIERSTRINGNUMBERLETANDORELSEFALSEFNFORIFNILRETURNSUPERTRUEWHILEBREAKCONTINUETRYCATCHFINALLYEOF"
 
-var _TokenType_index = [...]uint16{0, 10, 21, 31, 42, 54, 67, 72, 77, 80, 84, 89, 98, 103, 107, 111, 121, 126, 137, 144, 157, 161, 171, 181, 187, 193, 196, 199, 201, 205, 210, 212, 215, 217, 220, 226, 231, 235, 240, 245, 253, 256}
+var _TokenType_index = [...]uint16{0, 10, 21, 31, 42, 54, 67, 72, 77, 80, 84, 89, 98, 103, 107, 114, 123, 127, 132, 136, 146, 151, 162, 169, 182, 197, 201, 211, 220, 229, 234, 245, 255, 261, 267, 270, 273, 275, 279, 284, 286, 289, 291, 294, 300, 305, 309, 314, 319, 327, 330, 335, 342, 345}
 
 func (i TokenType) String() string {
 	i -= 1
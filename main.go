@@ -1,11 +1,21 @@
 package main
 
-// implements a toe repl
+// implements a toe repl, and a script runner:
+//
+//   toe                            starts the repl
+//   toe [-json] file.toe [args...] runs file.toe, with ARGV = [args...]
+//
+// with -json, errors are written to stderr as JSON objects (one per
+// line) instead of text, see diag.Report.
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/chzyer/readline"
 	"os"
+	"os/signal"
 	"strings"
 	"toe/eval"
 )
@@ -26,17 +36,51 @@ func sliceVersion(v string) string {
 	return v[0:m]
 }
 
-func reportErrors(errors []error) bool {
-	if len(errors) == 0 {
-		return false
-	}
-	for _, err := range errors {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
+var jsonErrors = flag.Bool("json", false, "write errors as JSON")
+
+// runScript runs the given file, returning the exit status.
+func runScript(filename string, args []string) int {
+	ctx := eval.NewContext()
+	ctx.SetArgs(args)
+	if _, err := ctx.RunFile(filename); err != nil {
+		if *jsonErrors {
+			enc := json.NewEncoder(os.Stderr)
+			enc.SetEscapeHTML(false)
+			for _, r := range ctx.Reports(err) {
+				enc.Encode(r)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, ctx.FormatError(err))
+		}
+		return 1
 	}
-	return true
+	return 0
+}
+
+// runInterruptible runs the line, aborting it on Ctrl-C.
+func runInterruptible(ctx *eval.InteractiveContext, line string) (eval.Value, []error) {
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-c.Done():
+		}
+	}()
+	ctx.SetContext(c)
+	defer ctx.SetContext(nil)
+	return ctx.Run(line)
 }
 
 func main() {
+	flag.Parse()
+	if flag.NArg() > 0 {
+		os.Exit(runScript(flag.Arg(0), flag.Args()[1:]))
+	}
 	fmt.Println(strings.Replace(LOGO, "$VERSION", sliceVersion(VERSION), 1))
 	rl, err := readline.New("> ")
 	if err != nil {
@@ -50,21 +94,21 @@ func main() {
 		if err != nil {
 			break
 		}
-		u, errs := ctx.Run(line)
+		u, errs := runInterruptible(ctx, line)
 		if errs != nil {
-			reportErrors(errs)
+			fmt.Fprintln(os.Stderr, ctx.FormatError(eval.SourceErrors(errs)))
 		} else {
 			if u == nil {
 				continue
 			} else if u.Type() == eval.VT_ERROR {
-				fmt.Fprintln(os.Stderr, u.(*eval.Error).String())
+				fmt.Fprintln(os.Stderr, ctx.FormatError(u.(*eval.Error)))
 			} else {
 				v, err := ctx.Inspect(u)
 				if err == nil {
 					fmt.Println(v)
 				} else {
 					fmt.Fprintln(os.Stderr, "inspect error:")
-					fmt.Fprintln(os.Stderr, err.String())
+					fmt.Fprintln(os.Stderr, ctx.FormatError(err))
 				}
 			}
 		}
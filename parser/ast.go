@@ -1,7 +1,14 @@
 package parser
 
+import "toe/lexer"
+
+// Node is a node of the AST. Span returns the range of the source it
+// was parsed from, e.g. `a.b(c)' for a Method node, including the
+// parentheses around grouped expressions, and the ';' of statements.
 type Node interface {
 	String() string
+	Span() lexer.Span
+	setSpan(lexer.Span)
 	node()
 }
 
@@ -17,13 +24,17 @@ type Stmt interface {
 
 // Resolvable implements the interface required by the resolver.
 // The resolver will add distance information (integers) onto
-// _resolvable_ nodes.
+// _resolvable_ nodes, along with the variable's slot in that
+// environment -- or -1 if it has to be looked up by name.
 type Resolvable interface {
-	AddLocation(loc int)
+	AddLocation(loc int, slot int)
 }
 
-func (node *Identifier) AddLocation(loc int) { node.Loc = loc }
-func (node *Assign) AddLocation(loc int)     { node.Loc = loc }
+func (node *Identifier) AddLocation(loc int, slot int) { node.Loc = loc; node.Slot = slot }
+func (node *Assign) AddLocation(loc int, slot int)     { node.Loc = loc; node.Slot = slot }
+
+// Get and Method nodes also have a Cache field, which is reserved for the
+// evaluator to cache slot lookups in.
 
 type Pair struct {
 	Key   Expr
@@ -6,6 +6,7 @@ import "toe/lexer"
 type Module struct {
 	Filename string
 	Stmts    []Stmt
+	span     lexer.Span
 }
 
 func newModule(Filename string, Stmts []Stmt) *Module {
@@ -14,12 +15,16 @@ func newModule(Filename string, Stmts []Stmt) *Module {
 		Stmts:    Stmts,
 	}
 }
-func (node *Module) node() {}
-func (node *Module) stmt() {}
+func (node *Module) Span() lexer.Span        { return node.span }
+func (node *Module) setSpan(span lexer.Span) { node.span = span }
+func (node *Module) node()                   {}
+func (node *Module) stmt()                   {}
 
 type Let struct {
 	Name  lexer.Token
 	Value Expr
+	Slot  int
+	span  lexer.Span
 }
 
 func newLet(Name lexer.Token, Value Expr) *Let {
@@ -28,11 +33,15 @@ func newLet(Name lexer.Token, Value Expr) *Let {
 		Value: Value,
 	}
 }
-func (node *Let) node() {}
-func (node *Let) stmt() {}
+func (node *Let) Span() lexer.Span        { return node.span }
+func (node *Let) setSpan(span lexer.Span) { node.span = span }
+func (node *Let) node()                   {}
+func (node *Let) stmt()                   {}
 
 type Block struct {
 	Stmts []Stmt
+	Size  int
+	span  lexer.Span
 }
 
 func newBlock(Stmts []Stmt) *Block {
@@ -40,14 +49,17 @@ func newBlock(Stmts []Stmt) *Block {
 		Stmts: Stmts,
 	}
 }
-func (node *Block) node() {}
-func (node *Block) stmt() {}
+func (node *Block) Span() lexer.Span        { return node.span }
+func (node *Block) setSpan(span lexer.Span) { node.span = span }
+func (node *Block) node()                   {}
+func (node *Block) stmt()                   {}
 
 type For struct {
 	Keyword lexer.Token
 	Name    lexer.Token
 	Iter    Expr
 	Stmt    Stmt
+	span    lexer.Span
 }
 
 func newFor(Keyword lexer.Token, Name lexer.Token, Iter Expr, Stmt Stmt) *For {
@@ -58,12 +70,15 @@ func newFor(Keyword lexer.Token, Name lexer.Token, Iter Expr, Stmt Stmt) *For {
 		Stmt:    Stmt,
 	}
 }
-func (node *For) node() {}
-func (node *For) stmt() {}
+func (node *For) Span() lexer.Span        { return node.span }
+func (node *For) setSpan(span lexer.Span) { node.span = span }
+func (node *For) node()                   {}
+func (node *For) stmt()                   {}
 
 type While struct {
 	Cond Expr
 	Stmt Stmt
+	span lexer.Span
 }
 
 func newWhile(Cond Expr, Stmt Stmt) *While {
@@ -72,13 +87,16 @@ func newWhile(Cond Expr, Stmt Stmt) *While {
 		Stmt: Stmt,
 	}
 }
-func (node *While) node() {}
-func (node *While) stmt() {}
+func (node *While) Span() lexer.Span        { return node.span }
+func (node *While) setSpan(span lexer.Span) { node.span = span }
+func (node *While) node()                   {}
+func (node *While) stmt()                   {}
 
 type If struct {
 	Cond Expr
 	Then Stmt
 	Else Stmt
+	span lexer.Span
 }
 
 func newIf(Cond Expr, Then Stmt, Else Stmt) *If {
@@ -88,11 +106,14 @@ func newIf(Cond Expr, Then Stmt, Else Stmt) *If {
 		Else: Else,
 	}
 }
-func (node *If) node() {}
-func (node *If) stmt() {}
+func (node *If) Span() lexer.Span        { return node.span }
+func (node *If) setSpan(span lexer.Span) { node.span = span }
+func (node *If) node()                   {}
+func (node *If) stmt()                   {}
 
 type ExprStmt struct {
 	Expr Expr
+	span lexer.Span
 }
 
 func newExprStmt(Expr Expr) *ExprStmt {
@@ -100,11 +121,14 @@ func newExprStmt(Expr Expr) *ExprStmt {
 		Expr: Expr,
 	}
 }
-func (node *ExprStmt) node() {}
-func (node *ExprStmt) stmt() {}
+func (node *ExprStmt) Span() lexer.Span        { return node.span }
+func (node *ExprStmt) setSpan(span lexer.Span) { node.span = span }
+func (node *ExprStmt) node()                   {}
+func (node *ExprStmt) stmt()                   {}
 
 type Break struct {
 	Keyword lexer.Token
+	span    lexer.Span
 }
 
 func newBreak(Keyword lexer.Token) *Break {
@@ -112,11 +136,14 @@ func newBreak(Keyword lexer.Token) *Break {
 		Keyword: Keyword,
 	}
 }
-func (node *Break) node() {}
-func (node *Break) stmt() {}
+func (node *Break) Span() lexer.Span        { return node.span }
+func (node *Break) setSpan(span lexer.Span) { node.span = span }
+func (node *Break) node()                   {}
+func (node *Break) stmt()                   {}
 
 type Continue struct {
 	Keyword lexer.Token
+	span    lexer.Span
 }
 
 func newContinue(Keyword lexer.Token) *Continue {
@@ -124,12 +151,15 @@ func newContinue(Keyword lexer.Token) *Continue {
 		Keyword: Keyword,
 	}
 }
-func (node *Continue) node() {}
-func (node *Continue) stmt() {}
+func (node *Continue) Span() lexer.Span        { return node.span }
+func (node *Continue) setSpan(span lexer.Span) { node.span = span }
+func (node *Continue) node()                   {}
+func (node *Continue) stmt()                   {}
 
 type Return struct {
 	Keyword lexer.Token
 	Expr    Expr
+	span    lexer.Span
 }
 
 func newReturn(Keyword lexer.Token, Expr Expr) *Return {
@@ -138,13 +168,39 @@ func newReturn(Keyword lexer.Token, Expr Expr) *Return {
 		Expr:    Expr,
 	}
 }
-func (node *Return) node() {}
-func (node *Return) stmt() {}
+func (node *Return) Span() lexer.Span        { return node.span }
+func (node *Return) setSpan(span lexer.Span) { node.span = span }
+func (node *Return) node()                   {}
+func (node *Return) stmt()                   {}
+
+type Try struct {
+	Keyword lexer.Token
+	Body    *Block
+	Name    lexer.Token
+	Catch   *Block
+	Finally *Block
+	span    lexer.Span
+}
+
+func newTry(Keyword lexer.Token, Body *Block, Name lexer.Token, Catch *Block, Finally *Block) *Try {
+	return &Try{
+		Keyword: Keyword,
+		Body:    Body,
+		Name:    Name,
+		Catch:   Catch,
+		Finally: Finally,
+	}
+}
+func (node *Try) Span() lexer.Span        { return node.span }
+func (node *Try) setSpan(span lexer.Span) { node.span = span }
+func (node *Try) node()                   {}
+func (node *Try) stmt()                   {}
 
 type Binary struct {
 	Left  Expr
 	Op    lexer.Token
 	Right Expr
+	span  lexer.Span
 }
 
 func newBinary(Left Expr, Op lexer.Token, Right Expr) *Binary {
@@ -154,13 +210,16 @@ func newBinary(Left Expr, Op lexer.Token, Right Expr) *Binary {
 		Right: Right,
 	}
 }
-func (node *Binary) node() {}
-func (node *Binary) expr() {}
+func (node *Binary) Span() lexer.Span        { return node.span }
+func (node *Binary) setSpan(span lexer.Span) { node.span = span }
+func (node *Binary) node()                   {}
+func (node *Binary) expr()                   {}
 
 type And struct {
 	Left  Expr
 	Op    lexer.Token
 	Right Expr
+	span  lexer.Span
 }
 
 func newAnd(Left Expr, Op lexer.Token, Right Expr) *And {
@@ -170,13 +229,16 @@ func newAnd(Left Expr, Op lexer.Token, Right Expr) *And {
 		Right: Right,
 	}
 }
-func (node *And) node() {}
-func (node *And) expr() {}
+func (node *And) Span() lexer.Span        { return node.span }
+func (node *And) setSpan(span lexer.Span) { node.span = span }
+func (node *And) node()                   {}
+func (node *And) expr()                   {}
 
 type Or struct {
 	Left  Expr
 	Op    lexer.Token
 	Right Expr
+	span  lexer.Span
 }
 
 func newOr(Left Expr, Op lexer.Token, Right Expr) *Or {
@@ -186,13 +248,17 @@ func newOr(Left Expr, Op lexer.Token, Right Expr) *Or {
 		Right: Right,
 	}
 }
-func (node *Or) node() {}
-func (node *Or) expr() {}
+func (node *Or) Span() lexer.Span        { return node.span }
+func (node *Or) setSpan(span lexer.Span) { node.span = span }
+func (node *Or) node()                   {}
+func (node *Or) expr()                   {}
 
 type Assign struct {
 	Name  lexer.Token
 	Right Expr
 	Loc   int
+	Slot  int
+	span  lexer.Span
 }
 
 func newAssign(Name lexer.Token, Right Expr) *Assign {
@@ -201,12 +267,15 @@ func newAssign(Name lexer.Token, Right Expr) *Assign {
 		Right: Right,
 	}
 }
-func (node *Assign) node() {}
-func (node *Assign) expr() {}
+func (node *Assign) Span() lexer.Span        { return node.span }
+func (node *Assign) setSpan(span lexer.Span) { node.span = span }
+func (node *Assign) node()                   {}
+func (node *Assign) expr()                   {}
 
 type Unary struct {
 	Op    lexer.Token
 	Right Expr
+	span  lexer.Span
 }
 
 func newUnary(Op lexer.Token, Right Expr) *Unary {
@@ -215,12 +284,16 @@ func newUnary(Op lexer.Token, Right Expr) *Unary {
 		Right: Right,
 	}
 }
-func (node *Unary) node() {}
-func (node *Unary) expr() {}
+func (node *Unary) Span() lexer.Span        { return node.span }
+func (node *Unary) setSpan(span lexer.Span) { node.span = span }
+func (node *Unary) node()                   {}
+func (node *Unary) expr()                   {}
 
 type Get struct {
 	Object Expr
 	Name   lexer.Token
+	Cache  interface{}
+	span   lexer.Span
 }
 
 func newGet(Object Expr, Name lexer.Token) *Get {
@@ -229,13 +302,16 @@ func newGet(Object Expr, Name lexer.Token) *Get {
 		Name:   Name,
 	}
 }
-func (node *Get) node() {}
-func (node *Get) expr() {}
+func (node *Get) Span() lexer.Span        { return node.span }
+func (node *Get) setSpan(span lexer.Span) { node.span = span }
+func (node *Get) node()                   {}
+func (node *Get) expr()                   {}
 
 type Set struct {
 	Object Expr
 	Name   lexer.Token
 	Right  Expr
+	span   lexer.Span
 }
 
 func newSet(Object Expr, Name lexer.Token, Right Expr) *Set {
@@ -245,14 +321,58 @@ func newSet(Object Expr, Name lexer.Token, Right Expr) *Set {
 		Right:  Right,
 	}
 }
-func (node *Set) node() {}
-func (node *Set) expr() {}
+func (node *Set) Span() lexer.Span        { return node.span }
+func (node *Set) setSpan(span lexer.Span) { node.span = span }
+func (node *Set) node()                   {}
+func (node *Set) expr()                   {}
+
+type Index struct {
+	Object   Expr
+	LBracket lexer.Token
+	Key      Expr
+	span     lexer.Span
+}
+
+func newIndex(Object Expr, LBracket lexer.Token, Key Expr) *Index {
+	return &Index{
+		Object:   Object,
+		LBracket: LBracket,
+		Key:      Key,
+	}
+}
+func (node *Index) Span() lexer.Span        { return node.span }
+func (node *Index) setSpan(span lexer.Span) { node.span = span }
+func (node *Index) node()                   {}
+func (node *Index) expr()                   {}
+
+type SetIndex struct {
+	Object   Expr
+	LBracket lexer.Token
+	Key      Expr
+	Right    Expr
+	span     lexer.Span
+}
+
+func newSetIndex(Object Expr, LBracket lexer.Token, Key Expr, Right Expr) *SetIndex {
+	return &SetIndex{
+		Object:   Object,
+		LBracket: LBracket,
+		Key:      Key,
+		Right:    Right,
+	}
+}
+func (node *SetIndex) Span() lexer.Span        { return node.span }
+func (node *SetIndex) setSpan(span lexer.Span) { node.span = span }
+func (node *SetIndex) node()                   {}
+func (node *SetIndex) expr()                   {}
 
 type Method struct {
 	Object Expr
 	Name   lexer.Token
 	LParen lexer.Token
 	Args   []Expr
+	Cache  interface{}
+	span   lexer.Span
 }
 
 func newMethod(Object Expr, Name lexer.Token, LParen lexer.Token, Args []Expr) *Method {
@@ -263,13 +383,16 @@ func newMethod(Object Expr, Name lexer.Token, LParen lexer.Token, Args []Expr) *
 		Args:   Args,
 	}
 }
-func (node *Method) node() {}
-func (node *Method) expr() {}
+func (node *Method) Span() lexer.Span        { return node.span }
+func (node *Method) setSpan(span lexer.Span) { node.span = span }
+func (node *Method) node()                   {}
+func (node *Method) expr()                   {}
 
 type Call struct {
 	Callee Expr
 	LParen lexer.Token
 	Args   []Expr
+	span   lexer.Span
 }
 
 func newCall(Callee Expr, LParen lexer.Token, Args []Expr) *Call {
@@ -279,12 +402,16 @@ func newCall(Callee Expr, LParen lexer.Token, Args []Expr) *Call {
 		Args:   Args,
 	}
 }
-func (node *Call) node() {}
-func (node *Call) expr() {}
+func (node *Call) Span() lexer.Span        { return node.span }
+func (node *Call) setSpan(span lexer.Span) { node.span = span }
+func (node *Call) node()                   {}
+func (node *Call) expr()                   {}
 
 type Identifier struct {
-	Id  lexer.Token
-	Loc int
+	Id   lexer.Token
+	Loc  int
+	Slot int
+	span lexer.Span
 }
 
 func newIdentifier(Id lexer.Token) *Identifier {
@@ -292,11 +419,14 @@ func newIdentifier(Id lexer.Token) *Identifier {
 		Id: Id,
 	}
 }
-func (node *Identifier) node() {}
-func (node *Identifier) expr() {}
+func (node *Identifier) Span() lexer.Span        { return node.span }
+func (node *Identifier) setSpan(span lexer.Span) { node.span = span }
+func (node *Identifier) node()                   {}
+func (node *Identifier) expr()                   {}
 
 type Literal struct {
-	Lit lexer.Token
+	Lit  lexer.Token
+	span lexer.Span
 }
 
 func newLiteral(Lit lexer.Token) *Literal {
@@ -304,11 +434,14 @@ func newLiteral(Lit lexer.Token) *Literal {
 		Lit: Lit,
 	}
 }
-func (node *Literal) node() {}
-func (node *Literal) expr() {}
+func (node *Literal) Span() lexer.Span        { return node.span }
+func (node *Literal) setSpan(span lexer.Span) { node.span = span }
+func (node *Literal) node()                   {}
+func (node *Literal) expr()                   {}
 
 type Array struct {
 	Exprs []Expr
+	span  lexer.Span
 }
 
 func newArray(Exprs []Expr) *Array {
@@ -316,12 +449,15 @@ func newArray(Exprs []Expr) *Array {
 		Exprs: Exprs,
 	}
 }
-func (node *Array) node() {}
-func (node *Array) expr() {}
+func (node *Array) Span() lexer.Span        { return node.span }
+func (node *Array) setSpan(span lexer.Span) { node.span = span }
+func (node *Array) node()                   {}
+func (node *Array) expr()                   {}
 
 type Hash struct {
 	LBrace lexer.Token
 	Pairs  []Pair
+	span   lexer.Span
 }
 
 func newHash(LBrace lexer.Token, Pairs []Pair) *Hash {
@@ -330,14 +466,17 @@ func newHash(LBrace lexer.Token, Pairs []Pair) *Hash {
 		Pairs:  Pairs,
 	}
 }
-func (node *Hash) node() {}
-func (node *Hash) expr() {}
+func (node *Hash) Span() lexer.Span        { return node.span }
+func (node *Hash) setSpan(span lexer.Span) { node.span = span }
+func (node *Hash) node()                   {}
+func (node *Hash) expr()                   {}
 
 type Function struct {
 	Fn     lexer.Token
 	Params []lexer.Token
 	Body   *Block
 	Name   string
+	span   lexer.Span
 }
 
 func newFunction(Fn lexer.Token, Params []lexer.Token, Body *Block) *Function {
@@ -347,11 +486,14 @@ func newFunction(Fn lexer.Token, Params []lexer.Token, Body *Block) *Function {
 		Body:   Body,
 	}
 }
-func (node *Function) node() {}
-func (node *Function) expr() {}
+func (node *Function) Span() lexer.Span        { return node.span }
+func (node *Function) setSpan(span lexer.Span) { node.span = span }
+func (node *Function) node()                   {}
+func (node *Function) expr()                   {}
 
 type Super struct {
-	Tok lexer.Token
+	Tok  lexer.Token
+	span lexer.Span
 }
 
 func newSuper(Tok lexer.Token) *Super {
@@ -359,5 +501,7 @@ func newSuper(Tok lexer.Token) *Super {
 		Tok: Tok,
 	}
 }
-func (node *Super) node() {}
-func (node *Super) expr() {}
+func (node *Super) Span() lexer.Span        { return node.span }
+func (node *Super) setSpan(span lexer.Span) { node.span = span }
+func (node *Super) node()                   {}
+func (node *Super) expr()                   {}
@@ -2,7 +2,9 @@ package parser
 
 import (
 	"fmt"
+	"toe/diag"
 	"toe/lexer"
+	"unicode/utf8"
 )
 
 // Represents a parsing error. We use this internally to signal
@@ -14,9 +16,17 @@ type ParserError struct {
 	Message  string
 }
 
-func (pe ParserError) Error() string { return pe.String() }
-func (pe ParserError) String() string {
-	return fmt.Sprintf("%s:%d:%d: %s", pe.Filename, pe.Token.Line, pe.Token.Column, pe.Message)
+func (pe ParserError) Error() string  { return pe.String() }
+func (pe ParserError) String() string { return pe.Diagnostic().String() }
+func (pe ParserError) Diagnostic() diag.Diagnostic {
+	return diag.Diagnostic{
+		Filename: pe.Filename,
+		Line:     pe.Token.Line,
+		Column:   pe.Token.Column,
+		Length:   utf8.RuneCountInString(pe.Token.Lexeme),
+		Kind:     "parser",
+		Message:  pe.Message,
+	}
 }
 
 func (p *Parser) error(tok lexer.Token, s string, args ...interface{}) error {
@@ -23,10 +23,15 @@ const (
 	PREC_AND     // and, or
 	PREC_EQ      // ==, !=
 	PREC_CMP     // <=, <, >, >=
+	PREC_BIT_OR  // |
+	PREC_BIT_XOR // ^
+	PREC_BIT_AND // &
+	PREC_SHIFT   // <<, >>
 	PREC_SUM     // +, -
-	PREC_PRODUCT // *, /
-	PREC_UNARY   // !, -
-	PREC_CALL    // (), .
+	PREC_PRODUCT // *, /, %, ~/
+	PREC_UNARY   // !, -, ~
+	PREC_POWER   // **
+	PREC_CALL    // (), ., []
 )
 
 // ====
@@ -50,6 +55,7 @@ func New(fn string, tokens []lexer.Token) *Parser {
 		lexer.NIL:          p.literal,
 		lexer.BANG:         p.unary,
 		lexer.MINUS:        p.unary,
+		lexer.TILDE:        p.unary,
 		lexer.LEFT_BRACKET: p.array,
 		lexer.LEFT_BRACE:   p.hash,
 		lexer.FN:           p.function,
@@ -58,38 +64,56 @@ func New(fn string, tokens []lexer.Token) *Parser {
 	// note: need to make sure that every entry in binaryParsers
 	// has a corresponding entry in precedences.
 	p.binaryParsers = map[lexer.TokenType]binaryParser{
-		lexer.EQUAL:         p.assign,
-		lexer.AND:           p.and,
-		lexer.OR:            p.or,
-		lexer.EQUAL_EQUAL:   p.binary,
-		lexer.BANG_EQUAL:    p.binary,
-		lexer.GREATER:       p.binary,
-		lexer.GREATER_EQUAL: p.binary,
-		lexer.LESS:          p.binary,
-		lexer.LESS_EQUAL:    p.binary,
-		lexer.PLUS:          p.binary,
-		lexer.MINUS:         p.binary,
-		lexer.STAR:          p.binary,
-		lexer.SLASH:         p.binary,
-		lexer.DOT:           p.get,
-		lexer.LEFT_PAREN:    p.call,
+		lexer.EQUAL:           p.assign,
+		lexer.AND:             p.and,
+		lexer.OR:              p.or,
+		lexer.EQUAL_EQUAL:     p.binary,
+		lexer.BANG_EQUAL:      p.binary,
+		lexer.GREATER:         p.binary,
+		lexer.GREATER_EQUAL:   p.binary,
+		lexer.LESS:            p.binary,
+		lexer.LESS_EQUAL:      p.binary,
+		lexer.PLUS:            p.binary,
+		lexer.MINUS:           p.binary,
+		lexer.STAR:            p.binary,
+		lexer.SLASH:           p.binary,
+		lexer.PERCENT:         p.binary,
+		lexer.TILDE_SLASH:     p.binary,
+		lexer.STAR_STAR:       p.power,
+		lexer.AMPERSAND:       p.binary,
+		lexer.PIPE:            p.binary,
+		lexer.CARET:           p.binary,
+		lexer.LESS_LESS:       p.binary,
+		lexer.GREATER_GREATER: p.binary,
+		lexer.DOT:             p.get,
+		lexer.LEFT_PAREN:      p.call,
+		lexer.LEFT_BRACKET:    p.index,
 	}
 	p.precedences = map[lexer.TokenType]int{
-		lexer.EQUAL:         PREC_ASSIGN,
-		lexer.AND:           PREC_AND,
-		lexer.OR:            PREC_AND,
-		lexer.EQUAL_EQUAL:   PREC_EQ,
-		lexer.BANG_EQUAL:    PREC_EQ,
-		lexer.GREATER:       PREC_CMP,
-		lexer.GREATER_EQUAL: PREC_CMP,
-		lexer.LESS:          PREC_CMP,
-		lexer.LESS_EQUAL:    PREC_CMP,
-		lexer.PLUS:          PREC_SUM,
-		lexer.MINUS:         PREC_SUM,
-		lexer.STAR:          PREC_PRODUCT,
-		lexer.SLASH:         PREC_PRODUCT,
-		lexer.DOT:           PREC_CALL,
-		lexer.LEFT_PAREN:    PREC_CALL,
+		lexer.EQUAL:           PREC_ASSIGN,
+		lexer.AND:             PREC_AND,
+		lexer.OR:              PREC_AND,
+		lexer.EQUAL_EQUAL:     PREC_EQ,
+		lexer.BANG_EQUAL:      PREC_EQ,
+		lexer.GREATER:         PREC_CMP,
+		lexer.GREATER_EQUAL:   PREC_CMP,
+		lexer.LESS:            PREC_CMP,
+		lexer.LESS_EQUAL:      PREC_CMP,
+		lexer.PLUS:            PREC_SUM,
+		lexer.MINUS:           PREC_SUM,
+		lexer.STAR:            PREC_PRODUCT,
+		lexer.SLASH:           PREC_PRODUCT,
+		lexer.PERCENT:         PREC_PRODUCT,
+		lexer.TILDE_SLASH:     PREC_PRODUCT,
+		lexer.STAR_STAR:       PREC_POWER,
+		lexer.AMPERSAND:       PREC_BIT_AND,
+		lexer.PIPE:            PREC_BIT_OR,
+		lexer.CARET:           PREC_BIT_XOR,
+		lexer.LESS_LESS:       PREC_SHIFT,
+		lexer.GREATER_GREATER: PREC_SHIFT,
+		lexer.DOT:             PREC_CALL,
+		lexer.LEFT_PAREN:      PREC_CALL,
+		lexer.LEFT_BRACKET:    PREC_CALL,
 	}
 	return p
 }
@@ -120,6 +144,28 @@ func (p *Parser) check(t lexer.TokenType) bool {
 	return !p.isAtEnd() && p.peek().Type == t
 }
 
+// spanFrom sets the span of node, from the start of the given span up
+// to the end of the most recently consumed token.
+func (p *Parser) spanFrom(start lexer.Span, node Node) {
+	if node != nil {
+		node.setSpan(start.To(p.previous().Span()))
+	}
+}
+
+// finishStmt and finishExpr set the span of the node with spanFrom, and
+// return the node.
+func (p *Parser) finishStmt(start lexer.Span, stmt Stmt) Stmt { p.spanFrom(start, stmt); return stmt }
+func (p *Parser) finishExpr(start lexer.Span, expr Expr) Expr { p.spanFrom(start, expr); return expr }
+
+// spanOf returns the span of expr, which is nil after some errors, e.g.
+// an invalid assignment target.
+func spanOf(expr Expr) lexer.Span {
+	if expr == nil {
+		return lexer.Span{}
+	}
+	return expr.Span()
+}
+
 // match consumes the token if it matches any of the given types
 func (p *Parser) match(types ...lexer.TokenType) bool {
 	for _, t := range types {
@@ -139,9 +185,14 @@ func (p *Parser) match(types ...lexer.TokenType) bool {
 
 func (p *Parser) Parse() *Module {
 	module := &Module{Filename: p.filename, Stmts: []Stmt{}}
+	start := p.peek().Span()
 	for !p.isAtEnd() {
 		module.Stmts = append(module.Stmts, p.declaration())
 	}
+	module.setSpan(start)
+	if p.curr > 0 {
+		p.spanFrom(start, module)
+	}
 	return module
 }
 
@@ -156,12 +207,14 @@ func (p *Parser) Parse() *Module {
 // the main entry point is the declaration rule:
 //
 //   declaration → let | statement
-//   statement   → for | while | if | break | continue | return | exprStmt
+//   statement   → for | while | if | try | break | continue | return | exprStmt
 //   let      → "let" IDENT "=" expression ";"
 //   for      → "for" "(" IDENT ":" expr ")" block
 //   while    → "while" "(" expr ")" block
 //   if       → "if" "(" expr ")" block ( "else" statement )?
 //   block    → "{" declaration* "}" | statement
+//   try      → "try" "{" block "}" ( "catch" "(" IDENT ")" "{" block "}" )?
+//                                  ( "finally" "{" block "}" )?
 //   break    → "break" ";"
 //   continue → "continue" ";"
 //   return   → "return" ( expr )? ";"
@@ -195,12 +248,13 @@ func (p *Parser) declaration() (stmt Stmt) {
 
 func (p *Parser) blockStmt() Stmt {
 	if p.match(lexer.LEFT_BRACE) {
+		start := p.previous().Span()
 		stmts := []Stmt{}
 		for !p.isAtEnd() && !p.check(lexer.RIGHT_BRACE) {
 			stmts = append(stmts, p.declaration())
 		}
 		p.expect(lexer.RIGHT_BRACE, "unclosed '{'")
-		return newBlock(stmts)
+		return p.finishStmt(start, newBlock(stmts))
 	} else {
 		return p.statement()
 	}
@@ -214,6 +268,8 @@ func (p *Parser) statement() Stmt {
 		return p.whileStmt()
 	case p.check(lexer.IF):
 		return p.ifStmt()
+	case p.check(lexer.TRY):
+		return p.tryStmt()
 	case p.check(lexer.CONTINUE):
 		return p.continueStmt()
 	case p.check(lexer.BREAK):
@@ -225,12 +281,12 @@ func (p *Parser) statement() Stmt {
 }
 
 func (p *Parser) letStmt() Stmt {
-	p.consume()
+	letToken := p.consume()
 	ident := p.expect(lexer.IDENTIFIER, "expect an identifier")
 	p.expect(lexer.EQUAL, "expect '=' after identifier")
 	expr := p.expression()
 	p.expect(lexer.SEMICOLON, "expect ';' after variable declaration")
-	return newLet(ident, expr)
+	return p.finishStmt(letToken.Span(), newLet(ident, expr))
 }
 
 func (p *Parser) forStmt() Stmt {
@@ -241,20 +297,20 @@ func (p *Parser) forStmt() Stmt {
 	iter := p.expression()
 	p.expect(lexer.RIGHT_PAREN, "unclosed '('")
 	stmt := p.blockStmt()
-	return newFor(forToken, ident, iter, stmt)
+	return p.finishStmt(forToken.Span(), newFor(forToken, ident, iter, stmt))
 }
 
 func (p *Parser) whileStmt() Stmt {
-	p.consume()
+	whileToken := p.consume()
 	p.expect(lexer.LEFT_PAREN, "expect '(' after 'while'")
 	cond := p.expression()
 	p.expect(lexer.RIGHT_PAREN, "unclosed '('")
 	stmt := p.blockStmt()
-	return newWhile(cond, stmt)
+	return p.finishStmt(whileToken.Span(), newWhile(cond, stmt))
 }
 
 func (p *Parser) ifStmt() Stmt {
-	p.consume()
+	ifToken := p.consume()
 	p.expect(lexer.LEFT_PAREN, "expect '(' after 'if'")
 	cond := p.expression()
 	p.expect(lexer.RIGHT_PAREN, "unclosed '('")
@@ -263,19 +319,47 @@ func (p *Parser) ifStmt() Stmt {
 	if p.match(lexer.ELSE) {
 		elseStmt = p.blockStmt()
 	}
-	return newIf(cond, then, elseStmt)
+	return p.finishStmt(ifToken.Span(), newIf(cond, then, elseStmt))
+}
+
+func (p *Parser) tryStmt() Stmt {
+	tryToken := p.consume()
+	body := p.braces("expected '{' after 'try'")
+	var name lexer.Token
+	var catch, finally *Block
+	if p.match(lexer.CATCH) {
+		p.expect(lexer.LEFT_PAREN, "expect '(' after 'catch'")
+		name = p.expect(lexer.IDENTIFIER, "expect an identifier after '('")
+		p.expect(lexer.RIGHT_PAREN, "unclosed '('")
+		catch = p.braces("expected '{' after 'catch'")
+	}
+	if p.match(lexer.FINALLY) {
+		finally = p.braces("expected '{' after 'finally'")
+	}
+	if catch == nil && finally == nil {
+		panic(p.error(tryToken, "expected 'catch' or 'finally' after 'try' block"))
+	}
+	return p.finishStmt(tryToken.Span(), newTry(tryToken, body, name, catch, finally))
+}
+
+// braces parses a block which has to be surrounded by braces.
+func (p *Parser) braces(s string) *Block {
+	if !p.check(lexer.LEFT_BRACE) {
+		panic(p.error(p.peek(), s))
+	}
+	return p.blockStmt().(*Block)
 }
 
 func (p *Parser) continueStmt() Stmt {
 	token := p.consume()
 	p.expect(lexer.SEMICOLON, "expect ';' after 'continue'")
-	return newContinue(token)
+	return p.finishStmt(token.Span(), newContinue(token))
 }
 
 func (p *Parser) breakStmt() Stmt {
 	token := p.consume()
 	p.expect(lexer.SEMICOLON, "expect ';' after 'break'")
-	return newBreak(token)
+	return p.finishStmt(token.Span(), newBreak(token))
 }
 
 func (p *Parser) returnStmt() Stmt {
@@ -285,7 +369,7 @@ func (p *Parser) returnStmt() Stmt {
 		expr = p.expression()
 	}
 	p.expect(lexer.SEMICOLON, "expect ';' after 'return'")
-	return newReturn(token, expr)
+	return p.finishStmt(token.Span(), newReturn(token, expr))
 }
 
 func (p *Parser) exprStmt() Stmt {
@@ -294,7 +378,7 @@ func (p *Parser) exprStmt() Stmt {
 	if expr == nil {
 		return nil
 	}
-	return newExprStmt(expr)
+	return p.finishStmt(expr.Span(), newExprStmt(expr))
 }
 
 // ==================
@@ -308,14 +392,16 @@ func (p *Parser) exprStmt() Stmt {
 //            | and | or
 //            | binary
 //            | unary
-//            | call | get
+//            | call | get | index
 //            | literal | super
-// assign   → ( get | IDENTIFIER ) "=" expression
+// assign   → ( get | index | IDENTIFIER ) "=" expression
 // and      → expression "and" expression
 // or       → expression "or" expression
 // binary   → expression ( "==" | "!=" | "<=" | ">=" | "<" | ">" | "+" | "-" | "*" | "/" ) expression
-// unary    → ( "!" | "-" ) expression
+//          | expression ( "%" | "~/" | "**" | "&" | "|" | "^" | "<<" | ">>" ) expression
+// unary    → ( "!" | "-" | "~" ) expression
 // get      → expression "." ( IDENTIFIER | "nil" | "true" | "false" )
+// index    → expression "[" expression "]"
 // call     → expression "(" args ")"
 // args     → expression ( "," args )? | ε
 // literal  → STRING | IDENTIFIER | NUMBER | TRUE | FALSE | NIL | function | array | hash
@@ -349,14 +435,14 @@ func (p *Parser) peekPrecedence() int {
 
 func (p *Parser) unary() Expr {
 	tok := p.consume()
-	return newUnary(tok, p.precedence(PREC_UNARY-1))
+	return p.finishExpr(tok.Span(), newUnary(tok, p.precedence(PREC_UNARY-1)))
 }
 
 func (p *Parser) grouping() Expr {
-	p.consume()
+	lParenTok := p.consume()
 	expr := p.expression()
 	p.expect(lexer.RIGHT_PAREN, "unclosed '('")
-	return expr
+	return p.finishExpr(lParenTok.Span(), expr)
 }
 
 func (p *Parser) assign(left Expr) Expr {
@@ -364,9 +450,11 @@ func (p *Parser) assign(left Expr) Expr {
 	right := p.precedence(PREC_ASSIGN - 1)
 	switch left := left.(type) {
 	case *Get:
-		return newSet(left.Object, left.Name, right)
+		return p.finishExpr(left.Span(), newSet(left.Object, left.Name, right))
+	case *Index:
+		return p.finishExpr(left.Span(), newSetIndex(left.Object, left.LBracket, left.Key, right))
 	case *Identifier:
-		return newAssign(left.Id, right)
+		return p.finishExpr(left.Span(), newAssign(left.Id, right))
 	default:
 		p.error(tok, "invalid assignment target")
 		return nil
@@ -378,36 +466,51 @@ func (p *Parser) get(left Expr) Expr {
 	name := p.consume()
 	switch name.Type {
 	case lexer.IDENTIFIER, lexer.NIL, lexer.TRUE, lexer.FALSE:
-		return newGet(left, name)
+		return p.finishExpr(spanOf(left), newGet(left, name))
 	}
 	panic(p.error(name, "expected a name after %q", tok.Lexeme))
 }
 
+func (p *Parser) index(left Expr) Expr {
+	lBracketTok := p.consume()
+	index := p.expression()
+	p.expect(lexer.RIGHT_BRACKET, "unclosed '['")
+	return p.finishExpr(spanOf(left), newIndex(left, lBracketTok, index))
+}
+
 func (p *Parser) binary(left Expr) Expr {
 	opToken := p.consume()
-	return newBinary(left, opToken, p.precedence(p.precedences[opToken.Type]))
+	return p.finishExpr(spanOf(left), newBinary(left, opToken, p.precedence(p.precedences[opToken.Type])))
+}
+
+// power is right-associative, i.e. a ** b ** c == a ** (b ** c).
+func (p *Parser) power(left Expr) Expr {
+	opToken := p.consume()
+	return p.finishExpr(spanOf(left), newBinary(left, opToken, p.precedence(PREC_POWER-1)))
 }
 
 func (p *Parser) and(left Expr) Expr {
 	opToken := p.consume()
-	return newAnd(left, opToken, p.precedence(PREC_AND))
+	return p.finishExpr(spanOf(left), newAnd(left, opToken, p.precedence(PREC_AND)))
 }
 
 func (p *Parser) or(left Expr) Expr {
 	opToken := p.consume()
-	return newOr(left, opToken, p.precedence(PREC_AND))
+	return p.finishExpr(spanOf(left), newOr(left, opToken, p.precedence(PREC_AND)))
 }
 
 func (p *Parser) identifier() Expr {
-	return newIdentifier(p.consume())
+	tok := p.consume()
+	return p.finishExpr(tok.Span(), newIdentifier(tok))
 }
 
 func (p *Parser) literal() Expr {
-	return newLiteral(p.consume())
+	tok := p.consume()
+	return p.finishExpr(tok.Span(), newLiteral(tok))
 }
 
 func (p *Parser) array() Expr {
-	p.consume()
+	lBracketTok := p.consume()
 	exprs := []Expr{}
 	for !p.isAtEnd() && !p.check(lexer.RIGHT_BRACKET) {
 		exprs = append(exprs, p.expression())
@@ -416,7 +519,7 @@ func (p *Parser) array() Expr {
 		}
 	}
 	p.expect(lexer.RIGHT_BRACKET, "unclosed '['")
-	return newArray(exprs)
+	return p.finishExpr(lBracketTok.Span(), newArray(exprs))
 }
 
 func (p *Parser) hash() Expr {
@@ -432,7 +535,7 @@ func (p *Parser) hash() Expr {
 		}
 	}
 	p.expect(lexer.RIGHT_BRACE, "unclosed '{'")
-	return newHash(lbrace, pairs)
+	return p.finishExpr(lbrace.Span(), newHash(lbrace, pairs))
 }
 
 func (p *Parser) function() Expr {
@@ -448,11 +551,8 @@ func (p *Parser) function() Expr {
 		}
 	}
 	p.expect(lexer.RIGHT_PAREN, "unclosed '('")
-	if !p.check(lexer.LEFT_BRACE) {
-		panic(p.error(p.peek(), "expected '{' after function params"))
-	}
-	block := p.blockStmt().(*Block)
-	return newFunction(fnTok, params, block)
+	block := p.braces("expected '{' after function params")
+	return p.finishExpr(fnTok.Span(), newFunction(fnTok, params, block))
 }
 
 func (p *Parser) call(left Expr) Expr {
@@ -467,13 +567,13 @@ func (p *Parser) call(left Expr) Expr {
 	p.expect(lexer.RIGHT_PAREN, "unclosed '('")
 	switch left := left.(type) {
 	case *Get:
-		return newMethod(left.Object, left.Name, lParenTok, args)
+		return p.finishExpr(left.Span(), newMethod(left.Object, left.Name, lParenTok, args))
 	default:
-		return newCall(left, lParenTok, args)
+		return p.finishExpr(spanOf(left), newCall(left, lParenTok, args))
 	}
 }
 
 func (p *Parser) super() Expr {
 	superToken := p.consume()
-	return p.get(newSuper(superToken))
+	return p.get(p.finishExpr(superToken.Span(), newSuper(superToken)))
 }
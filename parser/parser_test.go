@@ -1,6 +1,7 @@
 package parser_test
 
 import (
+	"strings"
 	"testing"
 	"toe/lexer"
 	"toe/parser"
@@ -22,6 +23,16 @@ func TestParserValid(t *testing.T) {
 		{"a + -b * c / d;", "(a + (((-b) * c) / d));"},
 		{"a / (c - f) / d + e;", "(((a / (c - f)) / d) + e);"},
 		{"a = b = c;", "(a = (b = c));"},
+		{"a % b ~/ c * d;", "(((a % b) ~/ c) * d);"},
+		{"a ** b ** c;", "(a ** (b ** c));"},
+		{"-a ** b;", "(-(a ** b));"},
+		{"a ** -b;", "(a ** (-b));"},
+		{"a * b ** c;", "(a * (b ** c));"},
+		{"~a & b;", "((~a) & b);"},
+		{"a | b ^ c & d;", "(a | (b ^ (c & d)));"},
+		{"a << b + c >> d;", "((a << (b + c)) >> d);"},
+		{"a & b == c | d;", "((a & b) == (c | d));"},
+		{"a | b < c;", "((a | b) < c);"},
 		{"for (x : a) true;", "for (x : a) true;"},
 		{"for (x : a) { let x = 2; b >= 10; }", "for (x : a) {let x = 2;(b >= 10);}"},
 		{"while (true) for (x : a) true;", "while (true) for (x : a) true;"},
@@ -55,6 +66,17 @@ func TestParserValid(t *testing.T) {
 		{"{1:2,2:3,};", "{1: 2, 2: 3};"},
 		{"{1:2,2:3,4:nil};", "{1: 2, 2: 3, 4: nil};"},
 		{"{1:2,};", "{1: 2};"},
+		{"a[1];", "(a[1]);"},
+		{"a[1][b + 2];", "((a[1])[(b + 2)]);"},
+		{"a.b[c](d);", "(((a.b)[c])(d));"},
+		{"a[1].b(c);", "((a[1]).b(c));"},
+		{"-a[1];", "(-(a[1]));"},
+		{"a[1] = b[2] = 3;", "(a[1] = (b[2] = 3));"},
+		{"a.b[1] = 2;", "((a.b)[1] = 2);"},
+		{"[1][0];", "([1][0]);"},
+		{"try { x; } catch (e) { y; }", "try {x;} catch (e) {y;}"},
+		{"try { x; } finally { y; }", "try {x;} finally {y;}"},
+		{"try {} catch (e) {} finally {}", "try {} catch (e) {} finally {}"},
 	}
 	for i, test := range tests {
 		var tokens []lexer.Token
@@ -102,6 +124,13 @@ func TestParserInvalid(t *testing.T) {
 		{"[1,2,3,,]", 1},
 		{"x[", 1},
 		{"x[a", 1},
+		{"x[]", 1},
+		{"x[a;", 1},
+		{"try {}", 1},
+		{"try x; catch (e) {}", 2}, // the dangling catch is an error too
+		{"try {} catch {}", 1},
+		{"try {} catch (e) x;", 1},
+		{"(1 = 2).x(3);", 1},
 	}
 	for i, test := range tests {
 		var tokens []lexer.Token
@@ -120,6 +149,54 @@ func TestParserInvalid(t *testing.T) {
 	}
 }
 
+func TestParserSpans(t *testing.T) {
+	tests := []struct {
+		input string
+		stmt  string // the span of the first statement.
+		expr  string // the span of its expression, for expression statements.
+	}{
+		{"  a + b * c;  ", "a + b * c;", "a + b * c"},
+		{"(a + b) * c;", "(a + b) * c;", "(a + b) * c"},
+		{"x.y(1, 2).z;", "x.y(1, 2).z;", "x.y(1, 2).z"},
+		{"f(\"阿福\")[0] = -1;", "f(\"阿福\")[0] = -1;", "f(\"阿福\")[0] = -1"},
+		{"[1, [2]];", "[1, [2]];", "[1, [2]]"},
+		{"{\"a\":\n1};", "{\"a\":\n1};", "{\"a\":\n1}"},
+		{"fn(a) {\n  return a;\n};", "fn(a) {\n  return a;\n};", "fn(a) {\n  return a;\n}"},
+		{"super.x(1);", "super.x(1);", "super.x(1)"},
+		{"let x = 1 ;", "let x = 1 ;", ""},
+		{"if (a) { b; } else c;", "if (a) { b; } else c;", ""},
+		{"for (x : y) {}", "for (x : y) {}", ""},
+		{"try {} finally {}", "try {} finally {}", ""},
+		{"return;", "return;", ""},
+	}
+	for i, test := range tests {
+		var tokens []lexer.Token
+		if !checkLexerErrors(t, test.input, &tokens) {
+			t.Errorf("tests[%d] (%q) failed", i, test.input)
+			continue
+		}
+		p := parser.New("", tokens)
+		module := p.Parse()
+		if len(p.Errors) != 0 {
+			t.Errorf("tests[%d] (%q): unexpected errors %v", i, test.input, p.Errors)
+			continue
+		}
+		text := func(span lexer.Span) string { return test.input[span.Start.Offset:span.End.Offset] }
+		if got := text(module.Span()); got != strings.TrimSpace(test.input) {
+			t.Errorf("tests[%d]: expected module span %q, got=%q", i, strings.TrimSpace(test.input), got)
+		}
+		stmt := module.Stmts[0]
+		if got := text(stmt.Span()); got != test.stmt {
+			t.Errorf("tests[%d]: expected statement span %q, got=%q", i, test.stmt, got)
+		}
+		if es, ok := stmt.(*parser.ExprStmt); ok {
+			if got := text(es.Expr.Span()); got != test.expr {
+				t.Errorf("tests[%d]: expected expression span %q, got=%q", i, test.expr, got)
+			}
+		}
+	}
+}
+
 func checkLexerErrors(t *testing.T, input string, out *[]lexer.Token) bool {
 	l := lexer.New("", input)
 	l.ScanTokens()
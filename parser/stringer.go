@@ -90,6 +90,24 @@ func (node *Return) String() string {
 	return buf.String()
 }
 
+func (node *Try) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(node.Keyword.Lexeme)
+	buf.WriteString(" ")
+	buf.WriteString(node.Body.String())
+	if node.Catch != nil {
+		buf.WriteString(" catch (")
+		buf.WriteString(node.Name.Lexeme)
+		buf.WriteString(") ")
+		buf.WriteString(node.Catch.String())
+	}
+	if node.Finally != nil {
+		buf.WriteString(" finally ")
+		buf.WriteString(node.Finally.String())
+	}
+	return buf.String()
+}
+
 // Expressions
 
 func (node *Assign) String() string {
@@ -160,6 +178,28 @@ func (node *Set) String() string {
 	return buf.String()
 }
 
+func (node *Index) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	buf.WriteString(node.Object.String())
+	buf.WriteString(node.LBracket.Lexeme)
+	buf.WriteString(node.Key.String())
+	buf.WriteString("])")
+	return buf.String()
+}
+
+func (node *SetIndex) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	buf.WriteString(node.Object.String())
+	buf.WriteString(node.LBracket.Lexeme)
+	buf.WriteString(node.Key.String())
+	buf.WriteString("] = ")
+	buf.WriteString(node.Right.String())
+	buf.WriteString(")")
+	return buf.String()
+}
+
 func (node *Unary) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(")
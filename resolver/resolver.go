@@ -2,14 +2,16 @@
 // as well as some syntax checks (e.g. ensuring that continues and breaks
 // are within a loop construct). Identifier resolution works by recording
 // the distance from the current environment where an identifier can be
-// found.
+// found, and its slot within that environment.
 package resolver
 
 import (
 	"errors"
 	"fmt"
+	"toe/diag"
 	"toe/lexer"
 	"toe/parser"
+	"unicode/utf8"
 )
 
 var TooManyErrors = errors.New("too many errors")
@@ -20,12 +22,44 @@ type ResolverError struct {
 	Message  string
 }
 
-func (re ResolverError) Error() string { return re.String() }
-func (re ResolverError) String() string {
-	return fmt.Sprintf("%s:%d:%d: %s", re.Filename, re.Token.Line, re.Token.Column, re.Message)
+func (re ResolverError) Error() string  { return re.String() }
+func (re ResolverError) String() string { return re.Diagnostic().String() }
+func (re ResolverError) Diagnostic() diag.Diagnostic {
+	return diag.Diagnostic{
+		Filename: re.Filename,
+		Line:     re.Token.Line,
+		Column:   re.Token.Column,
+		Length:   utf8.RuneCountInString(re.Token.Lexeme),
+		Kind:     "resolver",
+		Message:  re.Message,
+	}
+}
+
+// Scope maps the variables declared in a scope to their slots in the
+// environment. Variables in the global scope have no slots: they are
+// looked up by name, since functions may refer to globals which are
+// defined later on.
+type Scope struct {
+	vars   map[string]*variable
+	size   int
+	global bool
 }
 
-type Scope map[string]bool
+type variable struct {
+	slot        int
+	initialised bool
+}
+
+// declare adds an uninitialised variable to the scope.
+func (s *Scope) declare(name string) *variable {
+	v := &variable{slot: -1}
+	if !s.global {
+		v.slot = s.size
+		s.size++
+	}
+	s.vars[name] = v
+	return v
+}
 
 // Control flags -- whether we are in a loop, or a function.
 const (
@@ -35,9 +69,7 @@ const (
 
 type Resolver struct {
 	module *parser.Module
-	// each scope is a map from varname to a boolean, corresponding
-	// to whether the variable was already initialised.
-	scopes []Scope
+	scopes []*Scope
 	Errors []error
 	ctrl   uint8
 }
@@ -45,23 +77,24 @@ type Resolver struct {
 func New(module *parser.Module) *Resolver {
 	r := &Resolver{
 		module: module,
-		scopes: []Scope{},
+		scopes: []*Scope{},
 		Errors: []error{},
 		ctrl:   0,
 	}
 	r.push() // the global scope.
+	r.curr().global = true
 	return r
 }
 
 func (r *Resolver) AddGlobals(globals []string) {
 	for _, x := range globals {
-		r.scopes[0][x] = true
+		r.scopes[0].declare(x).initialised = true
 	}
 }
 
-func (r *Resolver) curr() Scope { return r.scopes[len(r.scopes)-1] }
-func (r *Resolver) push()       { r.scopes = append(r.scopes, Scope{}) }
-func (r *Resolver) pop()        { r.scopes = r.scopes[:len(r.scopes)-1] }
+func (r *Resolver) curr() *Scope { return r.scopes[len(r.scopes)-1] }
+func (r *Resolver) push()        { r.scopes = append(r.scopes, &Scope{vars: map[string]*variable{}}) }
+func (r *Resolver) pop()         { r.scopes = r.scopes[:len(r.scopes)-1] }
 
 func (r *Resolver) err(tok lexer.Token, msg string) {
 	r.Errors = append(r.Errors, ResolverError{
@@ -120,6 +153,8 @@ func (r *Resolver) resolve(node parser.Node) {
 		r.resolveContinue(node)
 	case *parser.Return:
 		r.resolveReturn(node)
+	case *parser.Try:
+		r.resolveTry(node)
 	// Expressions
 	case *parser.Binary:
 		r.resolveBinary(node)
@@ -135,6 +170,10 @@ func (r *Resolver) resolve(node parser.Node) {
 		r.resolveGet(node)
 	case *parser.Set:
 		r.resolveSet(node)
+	case *parser.Index:
+		r.resolveIndex(node)
+	case *parser.SetIndex:
+		r.resolveSetIndex(node)
 	case *parser.Method:
 		r.resolveMethod(node)
 	case *parser.Call:
@@ -163,13 +202,14 @@ func (r *Resolver) resolve(node parser.Node) {
 func (r *Resolver) resolveLet(node *parser.Let) {
 	name := node.Name.Lexeme
 	curr := r.curr()
-	if _, ok := curr[name]; ok {
+	if _, ok := curr.vars[name]; ok {
 		// is there already an existing let?
 		r.err(node.Name, "already a variable with this name in scope.")
 	}
-	curr[name] = false
+	v := curr.declare(name)
+	node.Slot = v.slot
 	r.resolve(node.Value)
-	curr[name] = true
+	v.initialised = true
 	addFunctionName(node.Value, name)
 }
 
@@ -178,6 +218,7 @@ func (r *Resolver) resolveBlock(node *parser.Block) {
 	for _, x := range node.Stmts {
 		r.resolve(x)
 	}
+	node.Size = r.curr().size
 	r.pop()
 }
 
@@ -187,7 +228,7 @@ func (r *Resolver) resolveFor(node *parser.For) {
 	r.push()
 	ctrl := r.ctrl
 	r.ctrl |= LOOP
-	r.curr()[name] = true
+	r.curr().declare(name).initialised = true // slot 0
 	r.resolve(node.Stmt)
 	r.ctrl = ctrl
 	r.pop()
@@ -234,6 +275,20 @@ func (r *Resolver) resolveReturn(node *parser.Return) {
 	}
 }
 
+func (r *Resolver) resolveTry(node *parser.Try) {
+	r.resolveBlock(node.Body)
+	if node.Catch != nil {
+		// the caught value lives in its own scope, like a for loop's variable.
+		r.push()
+		r.curr().declare(node.Name.Lexeme).initialised = true // slot 0
+		r.resolveBlock(node.Catch)
+		r.pop()
+	}
+	if node.Finally != nil {
+		r.resolveBlock(node.Finally)
+	}
+}
+
 // ===========
 // Expressions
 // ===========
@@ -273,6 +328,17 @@ func (r *Resolver) resolveSet(node *parser.Set) {
 	r.resolve(node.Object)
 }
 
+func (r *Resolver) resolveIndex(node *parser.Index) {
+	r.resolve(node.Object)
+	r.resolve(node.Key)
+}
+
+func (r *Resolver) resolveSetIndex(node *parser.SetIndex) {
+	r.resolve(node.Right)
+	r.resolve(node.Object)
+	r.resolve(node.Key)
+}
+
 func (r *Resolver) resolveMethod(node *parser.Method) {
 	r.resolve(node.Object)
 	for _, arg := range node.Args {
@@ -306,14 +372,16 @@ func (r *Resolver) resolveHash(node *parser.Hash) {
 
 func (r *Resolver) resolveFunction(node *parser.Function) {
 	// Function expressions -- we first push a new scope containing all
-	// of the parameters, and then we resolve the body.
+	// of the parameters, and then we resolve the body. Loops outside
+	// of the function cannot be broken out of from inside it.
 	ctrl := r.ctrl
-	r.ctrl |= FUNC
+	r.ctrl = FUNC
+	// The function's scope is laid out as [this, params...].
 	r.push()
 	scope := r.curr()
-	scope["this"] = true
+	scope.declare("this").initialised = true
 	for _, name := range node.Params {
-		scope[name.Lexeme] = true
+		scope.declare(name.Lexeme).initialised = true
 	}
 	r.resolveBlock(node.Body)
 	r.pop()
@@ -331,18 +399,18 @@ func (r *Resolver) lookup(node parser.Expr, token lexer.Token) {
 	curr := len(r.scopes) - 1
 	// loop until we find a closest scope containing the name.
 	for i := curr; i >= 0; i-- {
-		initialised, ok := r.scopes[i][name]
+		v, ok := r.scopes[i].vars[name]
 		if ok {
 			// if we're referring to an uninitialised variable, e.g.
 			// let a = a, then we can return an error -- unless:
 			//  1. we're in a function AND
 			//  2. we didn't find the name in the current scope.
 			// this is to allow functions to refer to themselves.
-			if !initialised && !((r.ctrl&FUNC) != 0 || i != curr) {
+			if !v.initialised && !((r.ctrl&FUNC) != 0 || i != curr) {
 				r.err(token, fmt.Sprintf("cannot access %q before initialization", name))
 				return
 			}
-			addLocation(node, curr-i)
+			addLocation(node, curr-i, v.slot)
 			return
 		}
 	}
@@ -356,18 +424,33 @@ func (r *Resolver) lookup(node parser.Expr, token lexer.Token) {
 		//      let a = 1;
 		//      x(2);
 		//
-		addLocation(node, curr)
+		addLocation(node, curr, -1)
 	} else {
-		r.err(token, fmt.Sprintf("undefined variable %q", name))
+		msg := fmt.Sprintf("undefined variable %q", name)
+		if s := diag.Suggest(name, r.names()); s != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", s)
+		}
+		r.err(token, msg)
+	}
+}
+
+// names returns the names of variables in scope, for suggestions.
+func (r *Resolver) names() []string {
+	names := []string{}
+	for _, scope := range r.scopes {
+		for name := range scope.vars {
+			names = append(names, name)
+		}
 	}
+	return names
 }
 
 // =========
 // Utilities
 // =========
 
-func addLocation(node parser.Expr, loc int) {
-	node.(parser.Resolvable).AddLocation(loc)
+func addLocation(node parser.Expr, loc int, slot int) {
+	node.(parser.Resolvable).AddLocation(loc, slot)
 }
 
 func addFunctionName(node parser.Expr, name string) {
@@ -56,6 +56,118 @@ if (1) {
 	}
 }
 
+func TestResolverSlots(t *testing.T) {
+	input := `
+let f = fn(a, b) {
+	let c = a;
+	for (x : b) {
+		let d = x;
+		c = d;
+	}
+	return c;
+};
+`
+	module := lexAndParse(t, input)
+	if module == nil {
+		return
+	}
+	r := resolver.New(module)
+	r.Resolve()
+	if !noErrors(t, "resolver", r.Errors) {
+		return
+	}
+	let_f := module.Stmts[0].(*parser.Let)
+	body := let_f.Value.(*parser.Function).Body
+	let_c := body.Stmts[0].(*parser.Let)
+	a := let_c.Value.(*parser.Identifier)
+	for_x := body.Stmts[1].(*parser.For)
+	for_body := for_x.Stmt.(*parser.Block)
+	let_d := for_body.Stmts[0].(*parser.Let)
+	x := let_d.Value.(*parser.Identifier)
+	assign_c := for_body.Stmts[1].(*parser.ExprStmt).Expr.(*parser.Assign)
+	return_c := body.Stmts[2].(*parser.Return).Expr.(*parser.Identifier)
+	tests := []struct {
+		name     string
+		got      int
+		expected int
+	}{
+		{"f slot", let_f.Slot, -1},
+		{"body size", body.Size, 1},
+		{"c slot", let_c.Slot, 0},
+		{"a loc", a.Loc, 1},
+		{"a slot", a.Slot, 1}, // [this, a, b]
+		{"for body size", for_body.Size, 1},
+		{"x loc", x.Loc, 1},
+		{"x slot", x.Slot, 0},
+		{"d slot", let_d.Slot, 0},
+		{"c = d loc", assign_c.Loc, 2},
+		{"c = d slot", assign_c.Slot, 0},
+		{"return c loc", return_c.Loc, 0},
+		{"return c slot", return_c.Slot, 0},
+	}
+	for _, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("expected %s=%d, got=%d", test.name, test.expected, test.got)
+		}
+	}
+}
+
+func TestResolverErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		errors int
+	}{
+		{"break;", 1},
+		{"continue;", 1},
+		{"return 1;", 1},
+		{"while (true) { break; continue; }", 0},
+		{"let f = fn() { return 1; };", 0},
+		// loops outside of a function cannot be controlled from inside it.
+		{"while (true) { let f = fn() { break; }; }", 1},
+		{"for (x : []) { fn() { continue; }; }", 1},
+		{"let f = fn() { while (true) { break; } };", 0},
+		{"let a = a;", 1},
+		{"let a = 1; let a = 2;", 1},
+		{"undefined;", 1},
+	}
+	for i, test := range tests {
+		module := lexAndParse(t, test.input)
+		if module == nil {
+			return
+		}
+		r := resolver.New(module)
+		r.Resolve()
+		if len(r.Errors) != test.errors {
+			t.Errorf("tests[%d] %q: expected %d errors, got=%v", i, test.input, test.errors, r.Errors)
+		}
+	}
+}
+
+func TestResolverSuggestions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let count = 1; cuont;", `undefined variable "cuont" (did you mean "count"?)`},
+		{"if (true) { let total = 1; if (true) { totl; } }", `undefined variable "totl" (did you mean "total"?)`},
+		{"if (true) { let total = 1; } totl;", `undefined variable "totl"`},
+		{"Objetc;", `undefined variable "Objetc" (did you mean "Object"?)`},
+		{"zzz;", `undefined variable "zzz"`},
+	}
+	for i, test := range tests {
+		module := lexAndParse(t, test.input)
+		if module == nil {
+			return
+		}
+		r := resolver.New(module)
+		r.AddGlobals([]string{"Object", "Array"})
+		r.Resolve()
+		if len(r.Errors) != 1 || r.Errors[0].(resolver.ResolverError).Message != test.expected {
+			t.Errorf("tests[%d] %q: expected %q, got=%v", i, test.input, test.expected, r.Errors)
+		}
+	}
+}
+
 // utils
 
 func lexAndParse(t *testing.T, input string) *parser.Module {
@@ -67,12 +67,21 @@ def token_method(field):
             "body": f"return node.{field}"}
 
 
+def span_methods(struct):
+    struct.extra_fields.append('span lexer.Span')
+    struct.methods.append({"method": "Span() lexer.Span",
+                           "body": "return node.span"})
+    struct.methods.append({"method": "setSpan(span lexer.Span)",
+                           "body": "node.span = span"})
+
+
 def generate(*, stmts, exprs):
     seen = set()
     structs = []
 
     for struct in stmts:
         assert struct.name not in seen
+        span_methods(struct)
         struct.methods.append({"method": "node()", "body": ""})
         struct.methods.append({"method": "stmt()", "body": ""})
         structs.append(struct)
@@ -80,6 +89,7 @@ def generate(*, stmts, exprs):
 
     for struct in exprs:
         assert struct.name not in seen
+        span_methods(struct)
         struct.methods.append({"method": "node()", "body": ""})
         struct.methods.append({"method": "expr()", "body": ""})
         structs.append(struct)
@@ -109,8 +119,8 @@ if __name__ == '__main__':
         # Statements
         stmts=[
             Struct('Module',   ['Filename string', 'Stmts []Stmt']),
-            Struct('Let',      ['Name lexer.Token', 'Value Expr']),
-            Struct('Block',    ['Stmts []Stmt']),
+            Struct('Let',      ['Name lexer.Token', 'Value Expr'], extra_fields=['Slot int']),
+            Struct('Block',    ['Stmts []Stmt'], extra_fields=['Size int']),
             Struct('For',      ['Keyword lexer.Token', 'Name lexer.Token', 'Iter Expr', 'Stmt Stmt']),
             Struct('While',    ['Cond Expr', 'Stmt Stmt']),
             Struct('If',       ['Cond Expr', 'Then Stmt', 'Else Stmt']),
@@ -118,19 +128,22 @@ if __name__ == '__main__':
             Struct('Break',    ['Keyword lexer.Token']),
             Struct('Continue', ['Keyword lexer.Token']),
             Struct('Return',   ['Keyword lexer.Token', 'Expr Expr']),
+            Struct('Try',      ['Keyword lexer.Token', 'Body *Block', 'Name lexer.Token', 'Catch *Block', 'Finally *Block']),
         ],
         # Expressions
         exprs=[
             Struct('Binary',     ['Left Expr', 'Op lexer.Token', 'Right Expr']),
             Struct('And',        ['Left Expr', 'Op lexer.Token', 'Right Expr']),
             Struct('Or',         ['Left Expr', 'Op lexer.Token', 'Right Expr']),
-            Struct('Assign',     ['Name lexer.Token', 'Right Expr'], extra_fields=['Loc int']),
+            Struct('Assign',     ['Name lexer.Token', 'Right Expr'], extra_fields=['Loc int', 'Slot int']),
             Struct('Unary',      ['Op lexer.Token', 'Right Expr']),
-            Struct('Get',        ['Object Expr', 'Name lexer.Token']),
+            Struct('Get',        ['Object Expr', 'Name lexer.Token'], extra_fields=['Cache interface{}']),
             Struct('Set',        ['Object Expr', 'Name lexer.Token', 'Right Expr']),
-            Struct('Method',     ['Object Expr', 'Name lexer.Token', 'LParen lexer.Token', 'Args []Expr']),
+            Struct('Index',      ['Object Expr', 'LBracket lexer.Token', 'Key Expr']),
+            Struct('SetIndex',   ['Object Expr', 'LBracket lexer.Token', 'Key Expr', 'Right Expr']),
+            Struct('Method',     ['Object Expr', 'Name lexer.Token', 'LParen lexer.Token', 'Args []Expr'], extra_fields=['Cache interface{}']),
             Struct('Call',       ['Callee Expr', 'LParen lexer.Token', 'Args []Expr']),
-            Struct('Identifier', ['Id lexer.Token'], extra_fields=['Loc int']),
+            Struct('Identifier', ['Id lexer.Token'], extra_fields=['Loc int', 'Slot int']),
             Struct('Literal',    ['Lit lexer.Token']),
             Struct('Array',      ['Exprs []Expr']),
             Struct('Hash',       ['LBrace lexer.Token', 'Pairs []Pair']),